@@ -0,0 +1,158 @@
+package backup_set
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BrightLocal/MySQLBackup/manifest"
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+// Policy is how many backup sets to keep by grandfather-father-son rules:
+// the newest complete set of each of the last Hourly hours, Daily days, Weekly weeks and Monthly months is kept
+type Policy struct {
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Enabled tells if the policy keeps anything, sets are not pruned otherwise
+func (p Policy) Enabled() bool {
+	return p.Hourly > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// set is a backup set found in the parent location, described by its manifest
+type set struct {
+	name     string
+	started  time.Time
+	complete bool
+}
+
+// Prune removes sets matching the pattern in the parent which are not kept by the policy, it returns names of removed sets.
+// The newest complete set is always kept, so are incomplete sets newer than it, as they may be running or resumed.
+// Sets without a manifest are never removed as their time is unknown
+func (p Policy) Prune(parent storage.Backend, pattern string) ([]string, error) {
+	names, err := parent.List(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var sets []set
+	for _, name := range names {
+		if IsLatest(name) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		s, err := readSet(parent, name)
+		if os.IsNotExist(err) {
+			log.Printf("Warning: backup set %q has no manifest, it is kept", name)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading manifest of backup set %q: %s", name, err)
+		}
+		sets = append(sets, s)
+	}
+	kept := p.keep(sets)
+	var removed []string
+	for _, s := range sets {
+		if kept[s.name] {
+			continue
+		}
+		if err := parent.RemoveAll(s.name); err != nil {
+			return removed, fmt.Errorf("error removing backup set %q: %s", s.name, err)
+		}
+		removed = append(removed, s.name)
+	}
+	return removed, nil
+}
+
+func readSet(parent storage.Backend, name string) (set, error) {
+	r, err := parent.Open(name + "/" + manifest.FileName)
+	if err != nil {
+		return set{}, err
+	}
+	defer r.Close()
+	m, err := manifest.Read(r)
+	if err != nil {
+		return set{}, err
+	}
+	return set{name: name, started: m.Started, complete: complete(m)}, nil
+}
+
+// IsComplete tells if the set in the parent is complete by its manifest
+func IsComplete(parent storage.Backend, name string) (bool, error) {
+	s, err := readSet(parent, name)
+	if err != nil {
+		return false, err
+	}
+	return s.complete, nil
+}
+
+// complete tells if the dump finished without interruption and failed tables, and all its tables are complete
+func complete(m *manifest.Manifest) bool {
+	if m.Finished.IsZero() || m.Interrupted || len(m.Failed) > 0 {
+		return false
+	}
+	for _, t := range m.Tables {
+		if !t.Complete {
+			return false
+		}
+	}
+	return true
+}
+
+// keep returns names of sets to keep
+func (p Policy) keep(sets []set) map[string]bool {
+	sort.Slice(sets, func(i, j int) bool { return sets[i].started.After(sets[j].started) })
+	kept := make(map[string]bool)
+	var newest *set
+	for i := range sets {
+		if sets[i].complete {
+			newest = &sets[i]
+			break
+		}
+	}
+	if newest == nil {
+		// nothing to fall back to, keep everything
+		for _, s := range sets {
+			kept[s.name] = true
+		}
+		return kept
+	}
+	kept[newest.name] = true
+	for _, rule := range []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{p.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	} {
+		periods := make(map[string]bool)
+		for _, s := range sets {
+			if len(periods) >= rule.count {
+				break
+			}
+			if !s.complete {
+				continue
+			}
+			if period := rule.period(s.started); !periods[period] {
+				periods[period] = true
+				kept[s.name] = true
+			}
+		}
+	}
+	for _, s := range sets {
+		if !s.complete && s.started.After(newest.started) {
+			kept[s.name] = true
+		}
+	}
+	return kept
+}
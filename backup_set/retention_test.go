@@ -0,0 +1,217 @@
+package backup_set
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/BrightLocal/MySQLBackup/manifest"
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+func TestKeep(t *testing.T) {
+	day := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC) }
+	sets := []set{
+		{name: "05T03", started: day(5, 3), complete: true},
+		{name: "05T02", started: day(5, 2), complete: true},
+		{name: "05T01", started: day(5, 1), complete: false},
+		{name: "04T03", started: day(4, 3), complete: true},
+		{name: "03T03", started: day(3, 3), complete: true},
+		{name: "02T03", started: day(2, 3), complete: true},
+		{name: "01T03", started: day(1, 3), complete: true},
+		{name: "05T04", started: day(5, 4), complete: false},
+	}
+	cases := []struct {
+		policy   Policy
+		expected []string
+	}{
+		// the newest complete set and newer incomplete ones are always kept
+		{Policy{}, []string{"05T03", "05T04"}},
+		{Policy{Hourly: 2}, []string{"05T02", "05T03", "05T04"}},
+		{Policy{Daily: 3}, []string{"03T03", "04T03", "05T03", "05T04"}},
+		// 1st to 3rd of March 2024 are in the week before
+		{Policy{Weekly: 2}, []string{"03T03", "05T03", "05T04"}},
+		{Policy{Hourly: 1, Monthly: 1}, []string{"05T03", "05T04"}},
+	}
+	for _, c := range cases {
+		var kept []string
+		for name := range c.policy.keep(append([]set{}, sets...)) {
+			kept = append(kept, name)
+		}
+		sort.Strings(kept)
+		if !reflect.DeepEqual(kept, c.expected) {
+			t.Errorf("%+v: expected %v, got %v", c.policy, c.expected, kept)
+		}
+	}
+
+	// nothing is removed when there's no complete set
+	incomplete := []set{{name: "a", started: day(1, 1)}, {name: "b", started: day(2, 1)}}
+	if kept := (Policy{Daily: 1}).keep(incomplete); len(kept) != 2 {
+		t.Errorf("Expected all sets kept, got %v", kept)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, started time.Time, complete bool) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		m := manifest.New("", started)
+		m.Finished = started.Add(time.Hour)
+		m.AddFile("users", nil, manifest.File{Name: "users.csjson"})
+		if complete {
+			m.Complete("users")
+		}
+		f, err := os.Create(filepath.Join(dir, name, manifest.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := m.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("2024-03-03", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), true)
+	write("2024-03-04", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), true)
+	write("2024-03-05", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), false)
+	if err := os.Mkdir(filepath.Join(dir, "2024-03-01"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	parent := storage.NewLocal(dir)
+	if err := WriteLatest(parent, LatestName, "2024-03-04"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Policy{Daily: 1}.Prune(parent, "*-*-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"2024-03-03"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected %v removed, got %v", expected, removed)
+	}
+	names, err := parent.List("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the set without manifest is not touched
+	if expected := []string{"2024-03-01", "2024-03-04", "2024-03-05", "latest"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v left, got %v", expected, names)
+	}
+}
+
+func TestPruneDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	parent := storage.NewLocal(dir)
+	now := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	// sets of two databases in one parent, the blog has only an old one
+	for _, s := range []struct {
+		database string
+		started  time.Time
+	}{
+		{"shop", now.AddDate(0, 0, -2)},
+		{"shop", now.AddDate(0, 0, -1)},
+		{"shop", now},
+		{"blog", now.AddDate(0, 0, -3)},
+	} {
+		set, err := New(dir+"/{database}-{date:2006-01-02}", s.database, s.started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(set.Location(), 0755); err != nil {
+			t.Fatal(err)
+		}
+		m := manifest.New("", s.started)
+		m.Finished = s.started.Add(time.Hour)
+		f, err := os.Create(filepath.Join(set.Location(), manifest.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Write(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteLatest(parent, set.Latest, set.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := New(dir+"/{database}-{date:2006-01-02}", "shop", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := Policy{Daily: 2}.Prune(parent, set.Pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"shop-2024-03-03"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected %v removed, got %v", expected, removed)
+	}
+	names, err := parent.List("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the other database keeps its set and its pointer
+	if expected := []string{"blog-2024-03-02", "latest-blog", "latest-shop", "shop-2024-03-04", "shop-2024-03-05"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v left, got %v", expected, names)
+	}
+	if latest, err := ReadLatest(parent, "latest-blog"); err != nil || latest != "blog-2024-03-02" {
+		t.Errorf("Got %q, %v", latest, err)
+	}
+}
+
+func TestPruneFailedTables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	parent := storage.NewLocal(dir)
+	for i, name := range []string{"2024-03-04", "2024-03-05"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		started := time.Date(2024, 3, 4+i, 0, 0, 0, 0, time.UTC)
+		m := manifest.New("", started)
+		m.Finished = started.Add(time.Hour)
+		m.AddFile("users", nil, manifest.File{Name: "users.csjson"})
+		m.Complete("users")
+		if name == "2024-03-05" {
+			// the run finished, but a table failed without any file
+			m.Fail("orders")
+		}
+		f, err := os.Create(filepath.Join(dir, name, manifest.FileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Write(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if complete, err := IsComplete(parent, "2024-03-05"); err != nil || complete {
+		t.Errorf("Expected the set with failed tables incomplete, got %v, %v", complete, err)
+	}
+	// the older set is the newest complete one, so it's kept
+	removed, err := Policy{Daily: 1}.Prune(parent, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("Expected nothing removed, got %v", removed)
+	}
+}
@@ -0,0 +1,184 @@
+package backup_set
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+// LatestName is the file next to backup sets which holds the name of the latest complete one,
+// it's followed by -database when sets of different databases are in the same parent
+const LatestName = "latest"
+
+var (
+	// placeholder is {database} or {date:layout} where layout is of Go time package
+	placeholder    = regexp.MustCompile(`\{(database|date:[^}]+)\}`)
+	anyPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// Set is a backup set: a directory named by the last element of a templated location,
+// next to sets of other runs in the parent location
+type Set struct {
+	// Parent is the location all sets are in
+	Parent string
+	// Name is the directory of this set in the parent
+	Name string
+	// Pattern matches names of all sets of the database in the parent
+	Pattern string
+	// Latest is the name of the latest pointer of the database in the parent
+	Latest string
+}
+
+// IsTemplate tells if the location has placeholders
+func IsTemplate(location string) bool {
+	return placeholder.MatchString(location)
+}
+
+// New expands placeholders of the location: {database} by the database name and {date:layout} by the time.
+// The date must be in the last element of the location and only there, so all sets are in the same parent
+func New(location, database string, now time.Time) (*Set, error) {
+	location = strings.TrimRight(location, "/")
+	i := strings.LastIndex(location, "/")
+	parent, name := location[:i+1], location[i+1:]
+	if strings.Contains(parent, "{date:") {
+		return nil, errors.New("{date:...} is only allowed in the last element of the location")
+	}
+	if !strings.Contains(name, "{date:") {
+		return nil, errors.New("the last element of the location must have {date:...}, so sets of different runs are kept apart")
+	}
+	if unknown := anyPlaceholder.FindString(placeholder.ReplaceAllString(location, "")); unknown != "" {
+		return nil, fmt.Errorf("unknown placeholder %s", unknown)
+	}
+	s := &Set{
+		Parent:  strings.TrimRight(expand(parent, database, now), "/"),
+		Name:    expand(name, database, now),
+		Pattern: pattern(name, database),
+		Latest:  LatestName,
+	}
+	if strings.Contains(name, "{database}") {
+		// sets of all databases are in the parent, each has its own pointer
+		s.Latest = LatestName + "-" + database
+	}
+	if s.Parent == "" && strings.HasPrefix(location, "/") {
+		s.Parent = "/"
+	} else if s.Parent == "" {
+		s.Parent = "."
+	}
+	if strings.Contains(s.Name, "/") {
+		return nil, fmt.Errorf("set name %q must not have /", s.Name)
+	}
+	return s, nil
+}
+
+func expand(template, database string, now time.Time) string {
+	return placeholder.ReplaceAllStringFunc(template, func(p string) string {
+		if p == "{database}" {
+			return database
+		}
+		return now.Format(strings.TrimSuffix(strings.TrimPrefix(p, "{date:"), "}"))
+	})
+}
+
+// pattern returns the glob matching the name expanded at any time: the date matches anything,
+// the rest is matched literally so sets of other databases in the same parent don't match
+func pattern(template, database string) string {
+	var glob strings.Builder
+	last := 0
+	for _, loc := range placeholder.FindAllStringIndex(template, -1) {
+		glob.WriteString(storage.EscapeGlob(template[last:loc[0]]))
+		if template[loc[0]:loc[1]] == "{database}" {
+			glob.WriteString(storage.EscapeGlob(database))
+		} else {
+			glob.WriteString("*")
+		}
+		last = loc[1]
+	}
+	glob.WriteString(storage.EscapeGlob(template[last:]))
+	return glob.String()
+}
+
+// IsLatest tells if the name is of a latest pointer
+func IsLatest(name string) bool {
+	return name == LatestName || strings.HasPrefix(name, LatestName+"-")
+}
+
+// Location is where the files of the set are stored
+func (s *Set) Location() string {
+	if s.Parent == "/" {
+		return "/" + s.Name
+	}
+	return s.Parent + "/" + s.Name
+}
+
+// WriteLatest points the latest pointer in the parent to the set
+func WriteLatest(parent storage.Backend, latest, name string) error {
+	w, err := parent.Create(latest + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(name + "\n")); err != nil {
+		storage.Abort(w)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return parent.Rename(latest+".tmp", latest)
+}
+
+// ReadLatest returns the name of the set the latest pointer in the parent points to
+func ReadLatest(parent storage.Backend, latest string) (string, error) {
+	r, err := parent.Open(latest)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(b))
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid latest pointer %q", name)
+	}
+	return name, nil
+}
+
+// ResolveLatest replaces the location ending with /latest or /latest-database by the location of the set
+// the pointer points to, other locations are returned as they are
+func ResolveLatest(location string, options storage.Options) (string, error) {
+	trimmed := strings.TrimRight(location, "/")
+	i := strings.LastIndex(trimmed, "/")
+	latest := trimmed[i+1:]
+	if !IsLatest(latest) {
+		return location, nil
+	}
+	var parent string
+	switch i {
+	case -1:
+		parent = "."
+	case 0:
+		parent = "/"
+	default:
+		parent = trimmed[:i]
+	}
+	backend, err := storage.New(parent, options)
+	if err != nil {
+		return "", err
+	}
+	defer backend.Close()
+	if info, err := backend.Stat(latest); err != nil || info.IsDir() {
+		// a directory named latest, or no pointer at all
+		return location, nil
+	}
+	name, err := ReadLatest(backend, latest)
+	if err != nil {
+		return "", err
+	}
+	return (&Set{Parent: parent, Name: name}).Location(), nil
+}
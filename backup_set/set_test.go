@@ -0,0 +1,77 @@
+package backup_set
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Date(2024, 3, 5, 7, 30, 0, 0, time.UTC)
+	for location, expected := range map[string]Set{
+		"/backups/{database}/{date:2006-01-02T15}":     {Parent: "/backups/shop", Name: "2024-03-05T07", Pattern: "*", Latest: "latest"},
+		"sftp://backup/db/{database}-{date:20060102}/": {Parent: "sftp://backup/db", Name: "shop-20240305", Pattern: "shop-*", Latest: "latest-shop"},
+		"s3://bucket/{date:2006-01}":                   {Parent: "s3://bucket", Name: "2024-03", Pattern: "*", Latest: "latest"},
+		"/{date:2006}":                                 {Parent: "/", Name: "2024", Pattern: "*", Latest: "latest"},
+		"{date:2006}":                                  {Parent: ".", Name: "2024", Pattern: "*", Latest: "latest"},
+		"/db/[{date:2006}]*":                           {Parent: "/db", Name: "[2024]*", Pattern: `\[*]\*`, Latest: "latest"},
+	} {
+		set, err := New(location, "shop", now)
+		if err != nil {
+			t.Errorf("%s: %s", location, err)
+			continue
+		}
+		if *set != expected {
+			t.Errorf("%s: expected %+v, got %+v", location, expected, *set)
+		}
+	}
+	for _, location := range []string{
+		"/backups/{date:2006}/db",
+		"/backups/{database}",
+		"/backups/{host}/{date:2006}",
+		"/backups/{date:2006/01}",
+	} {
+		if _, err := New(location, "shop", now); err == nil {
+			t.Errorf("%s: expected error", location)
+		}
+	}
+	if set, _ := New("/db/{database}_{date:2006}", "a*b", now); set.Pattern != `a\*b_*` {
+		t.Errorf("Expected the database matched literally, got %q", set.Pattern)
+	}
+	if set, _ := New("/{date:2006}", "", now); set.Location() != "/2024" {
+		t.Errorf("Got %q", set.Location())
+	}
+}
+
+func TestLatest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if location, err := ResolveLatest(dir+"/latest", storage.Options{}); err != nil || location != dir+"/latest" {
+		t.Errorf("Expected location as is without pointer, got %q, %v", location, err)
+	}
+	if err := WriteLatest(storage.NewLocal(dir), "latest", "2024-03-05T07"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLatest(storage.NewLocal(dir), "latest-shop", "shop-20240305"); err != nil {
+		t.Fatal(err)
+	}
+	if location, err := ResolveLatest(dir+"/latest/", storage.Options{}); err != nil || location != dir+"/2024-03-05T07" {
+		t.Errorf("Got %q, %v", location, err)
+	}
+	if location, err := ResolveLatest(dir+"/latest-shop", storage.Options{}); err != nil || location != dir+"/shop-20240305" {
+		t.Errorf("Got %q, %v", location, err)
+	}
+	if location, err := ResolveLatest(dir, storage.Options{}); err != nil || location != dir {
+		t.Errorf("Expected other locations as they are, got %q, %v", location, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "latest.tmp")); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file, got %v", err)
+	}
+}
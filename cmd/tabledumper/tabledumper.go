@@ -1,21 +1,42 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/BrightLocal/MySQLBackup/backup_set"
+	"github.com/BrightLocal/MySQLBackup/compression"
 	"github.com/BrightLocal/MySQLBackup/db_info"
 	"github.com/BrightLocal/MySQLBackup/dir_dumper"
+	"github.com/BrightLocal/MySQLBackup/encryption"
+	"github.com/BrightLocal/MySQLBackup/hooks"
+	"github.com/BrightLocal/MySQLBackup/metrics"
 	"github.com/BrightLocal/MySQLBackup/mylogin_reader"
+	"github.com/BrightLocal/MySQLBackup/progress"
+	"github.com/BrightLocal/MySQLBackup/storage"
 	"github.com/BrightLocal/MySQLBackup/worker_pool"
 	_ "github.com/go-sql-driver/mysql"
 )
 
+const (
+	// exitTablesFailed is the exit status when some tables failed, 1 is used when it could not start
+	exitTablesFailed = 3
+	// exitHooksFailed is the exit status when all tables were dumped but some hooks failed
+	exitHooksFailed = 4
+)
+
+// interrupted is the number of the signal which interrupted the run
+var interrupted int32
+
 type dumperConfig struct {
 	Hostname   string
 	Port       int
@@ -29,7 +50,31 @@ type dumperConfig struct {
 	Streams    int
 	DSN        string
 	RunAfter   string
+	OnStart    string
+	OnTable    string
+	OnFailed   string
+	OnDone     string
+	HookLimit  int
+	HookTime   time.Duration
 	WithHeader bool
+	Compress   string
+	Level      int
+	ChunkRows  int64
+	Lock       string
+	Progress   time.Duration
+	ProgressFD int
+	Metrics    string
+	Textfile   string
+	FailFast   bool
+	Resume     bool
+	KnownHosts string
+	Insecure   bool
+	Identity   string
+	S3Endpoint string
+	S3Region   string
+	Recipients string
+	Passphrase string
+	Retention  backup_set.Policy
 }
 
 func main() {
@@ -43,25 +88,88 @@ func main() {
 	flag.StringVar(&cfg.Password, "password", "", "Password")
 	flag.StringVar(&cfg.Tables, "tables", "", "Tables to dump (incompatible with -skip-tables)")
 	flag.StringVar(&cfg.SkipTables, "skip-tables", "", "Table names to skip (incompatible with -tables)")
-	flag.StringVar(&cfg.Dir, "dir", ".", "Destination directory path")
+	flag.StringVar(&cfg.Dir, "dir", ".", "Destination directory path or URL: file://, sftp:// or s3://, with {database} and {date:2006-01-02T15} placeholders in the last element for backup sets")
+	flag.StringVar(&cfg.KnownHosts, "known-hosts", "", "File to check sftp host keys against (default ~/.ssh/known_hosts)")
+	flag.BoolVar(&cfg.Insecure, "insecure-host-key", false, "Do not check sftp host keys")
+	flag.StringVar(&cfg.Identity, "identity-file", "", "Private key for sftp authentication, the passphrase is read from "+storage.PassphraseEnv)
+	flag.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "S3 compatible server, http:// or https:// URL (default AWS)")
+	flag.StringVar(&cfg.S3Region, "s3-region", "", "S3 bucket region (default detected)")
 	flag.StringVar(&cfg.RunAfter, "run-after", "", "Command to run after a file dump (%FILE_NAME% and %FILE_PATH% will be substituted)")
+	flag.StringVar(&cfg.OnStart, "on-run-start", "", "Command to run before the dump, the dump does not start if it fails")
+	flag.StringVar(&cfg.OnTable, "on-table-done", "", "Command to run after a table is dumped completely")
+	flag.StringVar(&cfg.OnFailed, "on-table-failed", "", "Command to run when a table fails")
+	flag.StringVar(&cfg.OnDone, "on-run-done", "", "Command to run when the dump finishes, successfully or not")
+	flag.IntVar(&cfg.HookLimit, "hook-concurrency", 4, "How many hooks to run at once")
+	flag.DurationVar(&cfg.HookTime, "hook-timeout", 10*time.Minute, "How long a hook may run before it is killed, 0 for no limit (-run-after has no limit unless it is set)")
 	flag.IntVar(&cfg.Streams, "streams", runtime.NumCPU(), "How many tables to dump in parallel")
 	flag.BoolVar(&cfg.WithHeader, "with-header", false, "Add header with column names to the backup")
+	flag.StringVar(&cfg.Compress, "compress", "bzip2", "Compression of data files: "+strings.Join(compression.Names(), ", "))
+	flag.StringVar(&cfg.Recipients, "encrypt-recipients-file", "", "File with age recipients to encrypt data files for, one per line (or "+encryption.RecipientsEnv+" variable)")
+	flag.StringVar(&cfg.Passphrase, "encrypt-passphrase-file", "", "File with the passphrase to encrypt data files by (or "+encryption.PassphraseEnv+" variable)")
+	flag.IntVar(&cfg.Level, "compress-level", 0, "Compression level, 0 for the default level of the codec")
+	flag.Int64Var(&cfg.ChunkRows, "chunk-rows", 0, "Split tables into primary key ranges of about that many rows dumped in parallel, 0 to disable")
+	flag.StringVar(&cfg.Lock, "lock", dir_dumper.LockAuto, "How to lock the server while snapshots are started: auto, ftwrl, backup, instance or none")
+	flag.DurationVar(&cfg.Progress, "progress", 30*time.Second, "How often to report progress, 0 to disable")
+	flag.IntVar(&cfg.ProgressFD, "progress-fd", 0, "File descriptor to write progress to as JSON lines")
+	flag.StringVar(&cfg.Metrics, "metrics-addr", "", "Address to serve Prometheus metrics on while running, e.g. :9104")
+	flag.StringVar(&cfg.Textfile, "metrics-textfile", "", "File to write metrics of a successful run to for node_exporter textfile collector")
+	flag.BoolVar(&cfg.FailFast, "fail-fast", false, "Stop at the first table which fails")
+	flag.IntVar(&cfg.Retention.Hourly, "keep-hourly", 0, "Backup sets to keep of the last hours, older ones are removed")
+	flag.IntVar(&cfg.Retention.Daily, "keep-daily", 0, "Backup sets to keep of the last days, older ones are removed")
+	flag.IntVar(&cfg.Retention.Weekly, "keep-weekly", 0, "Backup sets to keep of the last weeks, older ones are removed")
+	flag.IntVar(&cfg.Retention.Monthly, "keep-monthly", 0, "Backup sets to keep of the last months, older ones are removed")
+	flag.BoolVar(&cfg.Resume, "resume", false, "Resume the previous dump into the directory, dumping only tables it did not complete")
 	flag.Parse()
 	if cfg.Database == "" {
 		flag.Usage()
 		return
 	}
+	if !dir_dumper.IsLockMode(cfg.Lock) {
+		log.Fatalf("Error: unknown lock mode %q", cfg.Lock)
+	}
+	codec, err := compression.ByName(cfg.Compress)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	if err := codec.ValidLevel(cfg.Level); err != nil {
+		log.Printf("Error in -compress-level: %s", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	encrypter, err := encryption.NewEncrypter(cfg.Recipients, cfg.Passphrase)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	location := cfg.Dir
+	var set *backup_set.Set
+	if backup_set.IsTemplate(cfg.Dir) {
+		if set, err = backup_set.New(cfg.Dir, cfg.Database, time.Now()); err != nil {
+			log.Fatalf("Error in -dir: %s", err)
+		}
+		location = set.Location()
+		log.Printf("Dumping into backup set %s", location)
+	} else if cfg.Retention.Enabled() {
+		log.Fatal("Error: -keep-* options need -dir with placeholders")
+	}
 	cfg.buildDSN()
-	skipList := make(map[string]struct{})
 	if !(cfg.Tables == "" || cfg.SkipTables == "") {
 		flag.Usage()
 		return
 	}
-	if cfg.SkipTables != "" {
-		for _, t := range strings.Split(cfg.SkipTables, ",") {
-			skipList[strings.TrimSpace(t)] = struct{}{}
-		}
+	h := hooks.New(cfg.HookLimit, cfg.HookTime).
+		WithEnv("HOOK_DATABASE", cfg.Database).
+		WithEnv("HOOK_DIR", location).
+		On(hooks.RunStart, cfg.OnStart).
+		On(hooks.FileDone, cfg.RunAfter).
+		On(hooks.TableDone, cfg.OnTable).
+		On(hooks.TableFailed, cfg.OnFailed).
+		On(hooks.RunDone, cfg.OnDone)
+	if !isFlagSet("hook-timeout") {
+		// -run-after had no timeout before there were other hooks
+		h.WithTimeout(hooks.FileDone, 0)
+	}
+	if err := h.Run(hooks.RunStart, hooks.Env{}); err != nil {
+		log.Fatalf("Error: %s hook failed: %s", hooks.RunStart, err)
 	}
 	dbInfo, err := db_info.New(cfg.DSN)
 	if err != nil {
@@ -73,32 +181,211 @@ func main() {
 		log.Print("Database has no backup locks")
 	}
 	log.Printf("Will use %d streams", cfg.Streams)
+	tracker := cfg.newTracker()
+	m := metrics.New("tabledumper")
+	if cfg.Metrics != "" {
+		m.Serve(cfg.Metrics)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
+	options := storage.Options{
+		Create:          true,
+		Connections:     cfg.Streams,
+		KnownHosts:      cfg.KnownHosts,
+		InsecureHostKey: cfg.Insecure,
+		IdentityFile:    cfg.Identity,
+		S3Endpoint:      cfg.S3Endpoint,
+		S3Region:        cfg.S3Region,
+	}
+	backend, err := storage.New(location, options)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s", location, err)
+	}
 	dd := dir_dumper.
-		NewDirDumper(cfg.Dir, dbInfo).
+		NewDirDumper(backend, dbInfo).
+		WithContext(ctx).
 		WithHeader(cfg.WithHeader).
-		Connect(cfg.DSN).
-		RunAfter(cfg.RunAfter)
-	wp := worker_pool.NewPool(cfg.Streams, dd.Dump)
-	names := make(chan interface{})
-	go func() {
-		if cfg.Tables == "" {
-			// all tables except skipped
-			for _, tableName := range dbInfo.Tables() {
-				if _, ok := skipList[tableName]; !ok {
-					names <- tableName
-				}
-			}
-		} else {
-			// specific tables only
-			for _, tableName := range strings.Split(cfg.Tables, ",") {
-				names <- tableName
+		WithCompression(codec, cfg.Level).
+		WithEncryption(encrypter).
+		WithChunkRows(cfg.ChunkRows).
+		WithLock(cfg.Lock).
+		WithResume(cfg.Resume).
+		WithProgress(tracker).
+		WithMetrics(m).
+		WithHooks(h).
+		Connect(cfg.DSN, cfg.Streams)
+	tables, err := dbInfo.SelectTables(splitList(cfg.Tables), splitList(cfg.SkipTables))
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	dumped, err := dd.DumpSchema(tables)
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("Error dumping schema: %s", err)
+	}
+	if cfg.FailFast && len(dumped) < len(tables) {
+		// the error is reported with the stats
+		dumped = nil
+	}
+	tables = dumped
+	if err := dd.WriteMetadata(); err != nil {
+		log.Fatalf("Error writing metadata: %s", err)
+	}
+	var chunks []worker_pool.Job
+	for _, tableName := range tables {
+		if ctx.Err() != nil {
+			break
+		}
+		if dd.Completed(tableName) {
+			log.Printf("Table %q was completed by the previous run, skipping", tableName)
+			continue
+		}
+		tableChunks, err := dd.Split(tableName)
+		if err != nil {
+			// the error is reported with the stats, the table is skipped
+			if cfg.FailFast {
+				chunks = nil
+				break
 			}
+			continue
 		}
-		close(names)
-	}()
+		for _, chunk := range tableChunks {
+			chunks = append(chunks, chunk)
+		}
+	}
+	wp := worker_pool.NewPool(cfg.Streams, dd.Dump).WithFailFast(cfg.FailFast)
 	start := time.Now()
-	wp.Run(names)
-	dd.PrintStats(cfg.Streams, time.Now().Sub(start))
+	tracker.Start(cfg.Progress)
+	results := wp.Run(ctx, chunks)
+	tracker.Stop()
+	errs := worker_pool.Errors(results)
+	if err := dd.WriteManifest(); err != nil {
+		log.Fatalf("Error writing manifest: %s", err)
+	}
+	dd.Close()
+	if err := backend.Close(); err != nil {
+		log.Printf("Warning: error closing %s: %s", location, err)
+	}
+	duration := time.Now().Sub(start)
+	dd.PrintStats(cfg.Streams, duration)
+	reportSkipped(results)
+	total := dd.Total()
+	status := hooks.StatusOK
+	sig := atomic.LoadInt32(&interrupted)
+	if sig != 0 {
+		status = hooks.StatusInterrupted
+	} else if len(errs) > 0 || total.Errors > 0 || total.Failed > 0 {
+		status = hooks.StatusFailed
+	} else if set != nil {
+		if err := finishSet(set, options, cfg.Retention); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+	}
+	h.Run(hooks.RunDone, hooks.Env{
+		Rows:     total.Rows,
+		Bytes:    int64(total.Bytes),
+		Duration: duration,
+		Status:   status,
+	})
+	hooksFailed := h.Wait()
+	switch {
+	case sig != 0:
+		os.Exit(128 + int(sig))
+	case status == hooks.StatusFailed:
+		os.Exit(exitTablesFailed)
+	case hooksFailed > 0:
+		log.Printf("Error: %d hooks failed", hooksFailed)
+		os.Exit(exitHooksFailed)
+	}
+	if cfg.Textfile != "" {
+		if err := m.WriteTextfile(cfg.Textfile, duration, total.Rows, total.Bytes); err != nil {
+			log.Fatalf("Error writing metrics textfile: %s", err)
+		}
+	}
+}
+
+// isFlagSet tells if the flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// finishSet points the latest pointer to the complete backup set and removes old sets by the retention policy
+func finishSet(set *backup_set.Set, options storage.Options, retention backup_set.Policy) error {
+	parent, err := storage.New(set.Parent, options)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", set.Parent, err)
+	}
+	defer parent.Close()
+	if complete, err := backup_set.IsComplete(parent, set.Name); err != nil {
+		return fmt.Errorf("error reading manifest of %s: %s", set.Name, err)
+	} else if !complete {
+		return fmt.Errorf("backup set %s is not complete, the latest pointer is not moved", set.Name)
+	}
+	if err := backup_set.WriteLatest(parent, set.Latest, set.Name); err != nil {
+		return fmt.Errorf("error writing latest pointer: %s", err)
+	}
+	if !retention.Enabled() {
+		return nil
+	}
+	removed, err := retention.Prune(parent, set.Pattern)
+	for _, name := range removed {
+		log.Printf("Removed backup set %q", name)
+	}
+	return err
+}
+
+// handleSignals cancels the context on SIGINT or SIGTERM so the run stops cleanly, a second signal exits immediately
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		atomic.StoreInt32(&interrupted, int32(sig.(syscall.Signal)))
+		log.Printf("Received %s, stopping (send it again to exit immediately)", sig)
+		cancel()
+		sig = <-signals
+		log.Printf("Received %s, exiting", sig)
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
+
+// splitList splits the comma separated list of table names
+func splitList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// reportSkipped logs jobs which were not started because the run was interrupted or failed fast
+func reportSkipped(results []worker_pool.Result) {
+	skipped := worker_pool.Skipped(results)
+	if len(skipped) == 0 {
+		return
+	}
+	names := make([]string, len(skipped))
+	for i, job := range skipped {
+		names[i] = fmt.Sprint(job)
+	}
+	log.Printf("%d of %d chunks were not dumped: %s", len(skipped), len(results), strings.Join(names, ", "))
+}
+
+// newTracker returns the progress tracker writing JSON lines into the progress file descriptor if it's set
+func (c *dumperConfig) newTracker() *progress.Tracker {
+	tracker := progress.New()
+	if c.ProgressFD > 0 {
+		tracker.WithJSON(os.NewFile(uintptr(c.ProgressFD), "progress"))
+	}
+	return tracker
 }
 
 func (c *dumperConfig) buildDSN() {
@@ -144,5 +431,5 @@ func (c *dumperConfig) buildDSN() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	c.DSN += c.Database + "?charset=utf8"
+	c.DSN += c.Database + "?charset=utf8mb4,utf8"
 }
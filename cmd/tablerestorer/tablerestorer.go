@@ -1,20 +1,36 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/BrightLocal/MySQLBackup/backup_set"
 	"github.com/BrightLocal/MySQLBackup/dir_restorer"
+	"github.com/BrightLocal/MySQLBackup/encryption"
 	"github.com/BrightLocal/MySQLBackup/filter"
+	"github.com/BrightLocal/MySQLBackup/metrics"
 	"github.com/BrightLocal/MySQLBackup/mylogin_reader"
+	"github.com/BrightLocal/MySQLBackup/progress"
+	"github.com/BrightLocal/MySQLBackup/storage"
+	"github.com/BrightLocal/MySQLBackup/table_restorer"
 	"github.com/BrightLocal/MySQLBackup/worker_pool"
 )
 
+// exitTablesFailed is the exit status when some tables failed, 1 is used when it could not start
+const exitTablesFailed = 3
+
+// interrupted is the number of the signal which interrupted the run
+var interrupted int32
+
 type restorerConfig struct {
 	Hostname   string
 	Port       int
@@ -31,6 +47,21 @@ type restorerConfig struct {
 	Truncate   bool
 	Filter     string
 	DryRun     bool
+	BatchRows  int
+	BatchBytes int
+	Method     string
+	Progress   time.Duration
+	ProgressFD int
+	Metrics    string
+	Textfile   string
+	FailFast   bool
+	KnownHosts string
+	Insecure   bool
+	Identity   string
+	S3Endpoint string
+	S3Region   string
+	AgeKeys    string
+	Passphrase string
 }
 
 func main() {
@@ -44,17 +75,36 @@ func main() {
 	flag.StringVar(&cfg.Password, "password", "", "Password")
 	flag.StringVar(&cfg.Tables, "tables", "", "Tables to restore (incompatible with -skip-tables)")
 	flag.StringVar(&cfg.SkipTables, "skip-tables", "", "Table names to skip (incompatible with -tables)")
-	flag.StringVar(&cfg.Dir, "dir", ".", "Source directory path")
+	flag.StringVar(&cfg.Dir, "dir", ".", "Source directory path or URL: file://, sftp:// or s3://, ending with /latest or /latest-database for the latest backup set")
+	flag.StringVar(&cfg.KnownHosts, "known-hosts", "", "File to check sftp host keys against (default ~/.ssh/known_hosts)")
+	flag.BoolVar(&cfg.Insecure, "insecure-host-key", false, "Do not check sftp host keys")
+	flag.StringVar(&cfg.Identity, "identity-file", "", "Private key for sftp authentication, the passphrase is read from "+storage.PassphraseEnv)
+	flag.StringVar(&cfg.S3Endpoint, "s3-endpoint", "", "S3 compatible server, http:// or https:// URL (default AWS)")
+	flag.StringVar(&cfg.S3Region, "s3-region", "", "S3 bucket region (default detected)")
 	flag.BoolVar(&cfg.Create, "create", false, "Create tables if they do not exist")
 	flag.BoolVar(&cfg.Truncate, "truncate", false, "Clear tables before restoring")
 	flag.IntVar(&cfg.Streams, "streams", runtime.NumCPU(), "How many tables to restore in parallel")
+	flag.StringVar(&cfg.AgeKeys, "decrypt-identity-file", "", "File with age identities to decrypt data files by (or "+encryption.IdentityEnv+" variable)")
+	flag.StringVar(&cfg.Passphrase, "decrypt-passphrase-file", "", "File with the passphrase to decrypt data files by (or "+encryption.PassphraseEnv+" variable)")
 	flag.StringVar(&cfg.Filter, "filter", "", "Filter rows by expression")
 	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Dry run with print SQL into stdout")
+	flag.StringVar(&cfg.Method, "method", table_restorer.MethodInsert, "Restore method: "+table_restorer.MethodInsert+" or "+table_restorer.MethodLoadData)
+	flag.IntVar(&cfg.BatchRows, "batch-rows", 1000, "Rows to insert by a single statement")
+	flag.IntVar(&cfg.BatchBytes, "batch-bytes", 4<<20, "Bytes to insert by a single statement (limited by max_allowed_packet)")
+	flag.DurationVar(&cfg.Progress, "progress", 30*time.Second, "How often to report progress, 0 to disable")
+	flag.IntVar(&cfg.ProgressFD, "progress-fd", 0, "File descriptor to write progress to as JSON lines")
+	flag.StringVar(&cfg.Metrics, "metrics-addr", "", "Address to serve Prometheus metrics on while running, e.g. :9104")
+	flag.StringVar(&cfg.Textfile, "metrics-textfile", "", "File to write metrics of a successful run to for node_exporter textfile collector")
+	flag.BoolVar(&cfg.FailFast, "fail-fast", false, "Stop at the first table which fails")
 	flag.Parse()
 	if cfg.Database == "" {
 		flag.Usage()
 		return
 	}
+	if cfg.BatchRows < 1 || cfg.BatchBytes < 1 || !table_restorer.IsMethod(cfg.Method) {
+		flag.Usage()
+		return
+	}
 	cfg.buildDSN()
 	skipList := make(map[string]struct{})
 	if !(cfg.Tables == "" || cfg.SkipTables == "") {
@@ -71,41 +121,130 @@ func main() {
 	if err != nil {
 		log.Fatalf("error to parse filter (%s): %s", cfg.Filter, err)
 	}
+	decrypter, err := encryption.NewDecrypter(cfg.AgeKeys, cfg.Passphrase)
+	if err != nil {
+		log.Fatalf("error reading decryption keys: %s", err)
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(cancel)
+	tracker := cfg.newTracker()
+	m := metrics.New("tablerestorer")
+	if cfg.Metrics != "" {
+		m.Serve(cfg.Metrics)
+	}
+	options := storage.Options{
+		Connections:     cfg.Streams,
+		KnownHosts:      cfg.KnownHosts,
+		InsecureHostKey: cfg.Insecure,
+		IdentityFile:    cfg.Identity,
+		S3Endpoint:      cfg.S3Endpoint,
+		S3Region:        cfg.S3Region,
+	}
+	location, err := backup_set.ResolveLatest(cfg.Dir, options)
+	if err != nil {
+		log.Fatalf("error reading latest pointer of %s: %s", cfg.Dir, err)
+	}
+	if location != cfg.Dir {
+		log.Printf("Restoring from the latest backup set %s", location)
+	}
+	backend, err := storage.New(location, options)
+	if err != nil {
+		log.Fatalf("error opening %s: %s", location, err)
+	}
 	dr := dir_restorer.
-		NewDirRestorer(cfg.Dir).
+		NewDirRestorer(backend).
 		WithFilter(dataFilter).
+		WithDecryption(decrypter).
+		WithProgress(tracker).
+		WithMetrics(m).
 		WithDryRun(cfg.DryRun).
+		WithBatch(cfg.BatchRows, cfg.BatchBytes).
+		WithMethod(cfg.Method).
 		Connect(cfg.DSN, cfg.Database).
 		CreateTables(cfg.Create).
 		TruncateTables(cfg.Truncate)
 	if err := dr.Prepare(); err != nil {
 		log.Fatalf("error preparing database: %s", err)
 	}
-	wp := worker_pool.NewPool(cfg.Streams, dr.Restore)
-	names := make(chan interface{})
-	go func() {
-		if cfg.Tables == "" {
-			// all tables except skipped
-			for _, tableName := range dr.Tables() {
-				if _, ok := skipList[tableName]; !ok {
-					names <- tableName
-				}
-			}
-		} else {
-			// specific tables only
-			for _, tableName := range strings.Split(cfg.Tables, ",") {
-				names <- tableName
+	var tables []string
+	if cfg.Tables == "" {
+		// all tables except skipped
+		for _, tableName := range dr.Tables() {
+			if _, ok := skipList[tableName]; !ok {
+				tables = append(tables, tableName)
 			}
 		}
-		close(names)
-	}()
+	} else {
+		// specific tables only
+		tables = strings.Split(cfg.Tables, ",")
+	}
+	jobs := dr.Estimate(tables)
+	wp := worker_pool.NewPool(cfg.Streams, dr.Restore).WithFailFast(cfg.FailFast)
 	start := time.Now()
-	wp.Run(names)
+	tracker.Start(cfg.Progress)
+	results := wp.Run(ctx, jobs)
+	tracker.Stop()
+	errs := worker_pool.Errors(results)
 	if err := dr.Finish(); err != nil {
 		log.Fatalf("error doing final tasks: %s", err)
 	}
-	dr.PrintStats(cfg.Streams, time.Now().Sub(start))
+	if err := backend.Close(); err != nil {
+		log.Printf("warning: error closing %s: %s", location, err)
+	}
+	duration := time.Now().Sub(start)
+	dr.PrintStats(cfg.Streams, duration)
+	reportSkipped(results)
+	if sig := atomic.LoadInt32(&interrupted); sig != 0 {
+		os.Exit(128 + int(sig))
+	}
+	total := dr.Total()
+	if len(errs) > 0 || total.Errors > 0 || total.Failed > 0 {
+		os.Exit(exitTablesFailed)
+	}
+	if cfg.Textfile != "" {
+		if err := m.WriteTextfile(cfg.Textfile, duration, total.Rows, total.Bytes); err != nil {
+			log.Fatalf("Error writing metrics textfile: %s", err)
+		}
+	}
+}
+
+// handleSignals cancels the context on SIGINT or SIGTERM so the run stops cleanly, a second signal exits immediately
+func handleSignals(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		atomic.StoreInt32(&interrupted, int32(sig.(syscall.Signal)))
+		log.Printf("Received %s, stopping (send it again to exit immediately)", sig)
+		cancel()
+		sig = <-signals
+		log.Printf("Received %s, exiting", sig)
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
+
+// reportSkipped logs jobs which were not started because the run was interrupted or failed fast
+func reportSkipped(results []worker_pool.Result) {
+	skipped := worker_pool.Skipped(results)
+	if len(skipped) == 0 {
+		return
+	}
+	names := make([]string, len(skipped))
+	for i, job := range skipped {
+		names[i] = fmt.Sprint(job)
+	}
+	log.Printf("%d of %d tables were not restored: %s", len(skipped), len(results), strings.Join(names, ", "))
+}
+
+// newTracker returns the progress tracker writing JSON lines into the progress file descriptor if it's set
+func (c *restorerConfig) newTracker() *progress.Tracker {
+	tracker := progress.New()
+	if c.ProgressFD > 0 {
+		tracker.WithJSON(os.NewFile(uintptr(c.ProgressFD), "progress"))
+	}
+	return tracker
 }
 
 func (c *restorerConfig) buildDSN() {
@@ -151,5 +290,5 @@ func (c *restorerConfig) buildDSN() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	c.DSN += c.Database + "?charset=utf8"
+	c.DSN += c.Database + "?charset=utf8mb4,utf8"
 }
@@ -0,0 +1,177 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// DataSuffix is the suffix of uncompressed data files, codecs append their own suffix to it
+const DataSuffix = ".csjson"
+
+// Codec is a compression format. Level 0 means the default level of the codec
+type Codec struct {
+	Name      string
+	Suffix    string
+	magic     []byte
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = []*Codec{
+	{
+		Name:   "zstd",
+		Suffix: ".zst",
+		magic:  []byte{0x28, 0xb5, 0x2f, 0xfd},
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				return zstd.NewWriter(w)
+			}
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("zstd compression level must be between 1 and 22 or 0 for the default, got %d", level)
+			}
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		Name:   "gzip",
+		Suffix: ".gz",
+		magic:  []byte{0x1f, 0x8b},
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		Name:   "lz4",
+		Suffix: ".lz4",
+		magic:  []byte{0x04, 0x22, 0x4d, 0x18},
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level < 0 || level > 9 {
+				return nil, fmt.Errorf("lz4 compression level must be between 1 and 9 or 0 for the default, got %d", level)
+			}
+			zw := lz4.NewWriter(w)
+			if level > 0 {
+				if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << uint(8+level)))); err != nil {
+					return nil, err
+				}
+			}
+			return zw, nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(lz4.NewReader(r)), nil
+		},
+	},
+	{
+		Name:   "bzip2",
+		Suffix: ".bz2",
+		magic:  []byte("BZh"),
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = bzip2.BestCompression
+			}
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return bzip2.NewReader(r, nil)
+		},
+	},
+	{
+		Name:   "none",
+		Suffix: "",
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		},
+	},
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ByName returns the codec by its name
+func ByName(name string) (*Codec, error) {
+	for _, c := range codecs {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression %q", name)
+}
+
+// Names lists names of all supported codecs
+func Names() []string {
+	names := make([]string, 0, len(codecs))
+	for _, c := range codecs {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+// FileSuffix is the suffix of data files written with the codec
+func (c *Codec) FileSuffix() string {
+	return DataSuffix + c.Suffix
+}
+
+func (c *Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return c.newWriter(w, level)
+}
+
+// ValidLevel checks the level by making a writer, so a bad level is found before anything is dumped
+func (c *Codec) ValidLevel(level int) error {
+	w, err := c.newWriter(ioutil.Discard, level)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (c *Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return c.newReader(r)
+}
+
+// Detect finds the codec of a file by its magic bytes, then by its name suffix.
+// Returned reader must be used instead of r as it has the peeked bytes buffered
+func Detect(fileName string, r io.Reader) (*Codec, io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, br, err
+	}
+	for _, c := range codecs {
+		if len(c.magic) > 0 && bytes.HasPrefix(head, c.magic) {
+			return c, br, nil
+		}
+	}
+	for _, c := range codecs {
+		if strings.HasSuffix(fileName, c.FileSuffix()) {
+			return c, br, nil
+		}
+	}
+	return nil, br, fmt.Errorf("could not detect compression format for file %q", fileName)
+}
@@ -0,0 +1,114 @@
+package compression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte("1050,\"yellowbot\",null,\"\"\n2,\"multi line\\nvalue\",,\"x\"\n")
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			c, err := ByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := &bytes.Buffer{}
+			w, err := c.NewWriter(b, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// name without suffix, so the codec is detected by magic bytes, except for uncompressed data
+			fileName := "table" + DataSuffix
+			detected, r, err := Detect(fileName, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detected != c {
+				t.Fatalf("Detected %q instead of %q", detected.Name, c.Name)
+			}
+			dr, err := detected.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadAll(dr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("Got %q", out)
+			}
+		})
+	}
+}
+
+func TestDetectBySuffix(t *testing.T) {
+	c, _, err := Detect("table.csjson.gz", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "gzip" {
+		t.Errorf("Got %q", c.Name)
+	}
+	if _, _, err := Detect("table.txt", bytes.NewReader([]byte("hello"))); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestByName(t *testing.T) {
+	c, err := ByName("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FileSuffix() != ".csjson.zst" {
+		t.Errorf("Got %q", c.FileSuffix())
+	}
+	if _, err := ByName("rar"); err == nil {
+		t.Error("Expected error for unknown codec")
+	}
+}
+
+func TestLZ4Level(t *testing.T) {
+	c, err := ByName("lz4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for level, valid := range map[int]bool{-1: false, 0: true, 1: true, 9: true, 10: false} {
+		_, err := c.NewWriter(ioutil.Discard, level)
+		if valid && err != nil {
+			t.Errorf("Level %d: %s", level, err)
+		} else if !valid && err == nil {
+			t.Errorf("Level %d: expected an error", level)
+		}
+	}
+}
+
+func TestValidLevel(t *testing.T) {
+	for name, levels := range map[string]map[int]bool{
+		"zstd":  {-1: false, 0: true, 1: true, 22: true, 23: false},
+		"gzip":  {-3: false, 0: true, 1: true, 9: true, 10: false},
+		"bzip2": {-1: false, 0: true, 1: true, 9: true, 10: false},
+		"lz4":   {-1: false, 0: true, 9: true, 10: false},
+		"none":  {0: true, 5: true},
+	} {
+		c, err := ByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for level, valid := range levels {
+			err := c.ValidLevel(level)
+			if valid && err != nil {
+				t.Errorf("%s level %d: %s", name, level, err)
+			} else if !valid && err == nil {
+				t.Errorf("%s level %d: expected an error", name, level)
+			}
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package db_info
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
@@ -15,20 +18,50 @@ type DBInfo struct {
 		yes     bool
 	}
 	tableColumnTypes map[string][]string
-	masterStatus     struct {
-		File            string `db:"File"`
-		Position        int    `db:"Position"`
-		DoDB            string `db:"Binlog_Do_DB"`
-		IgnoreDB        string `db:"Binlog_Ignore_DB"`
-		ExecutedGtidSet string `db:"Executed_Gtid_Set"`
-	}
-	isMaster bool
+	tableColumnDefs  map[string][]Column
+	tableErrors      map[string]error
+	masterStatus     MasterStatus
+	isMaster         bool
+}
+
+// Column is a table column as reported by SHOW COLUMNS
+type Column struct {
+	Name string
+	Type string
+}
+
+// MasterStatus is the binary log position of the server
+type MasterStatus struct {
+	File            string `db:"File" json:"file"`
+	Position        int    `db:"Position" json:"position"`
+	DoDB            string `db:"Binlog_Do_DB" json:"binlog_do_db,omitempty"`
+	IgnoreDB        string `db:"Binlog_Ignore_DB" json:"binlog_ignore_db,omitempty"`
+	ExecutedGtidSet string `db:"Executed_Gtid_Set" json:"executed_gtid_set,omitempty"`
+}
+
+// SlaveStatus is the position of a replication channel when the server is a replica
+type SlaveStatus struct {
+	ChannelName        string `json:"channel_name,omitempty"`
+	MasterHost         string `json:"master_host"`
+	MasterPort         int    `json:"master_port"`
+	RelayMasterLogFile string `json:"relay_master_log_file"`
+	ExecMasterLogPos   int    `json:"exec_master_log_pos"`
+	RetrievedGtidSet   string `json:"retrieved_gtid_set,omitempty"`
+	ExecutedGtidSet    string `json:"executed_gtid_set,omitempty"`
+}
+
+// ReplicationStatus holds binary log coordinates matching a snapshot
+type ReplicationStatus struct {
+	Master *MasterStatus `json:"master,omitempty"`
+	Slave  []SlaveStatus `json:"slave,omitempty"`
 }
 
 func New(dsn string) (*DBInfo, error) {
 	i := &DBInfo{
 		dsn:              dsn,
 		tableColumnTypes: make(map[string][]string),
+		tableColumnDefs:  make(map[string][]Column),
+		tableErrors:      make(map[string]error),
 	}
 	return i, i.Ping()
 }
@@ -42,26 +75,261 @@ func (i *DBInfo) Ping() error {
 	return i.conn.Ping()
 }
 
-func (i *DBInfo) Tables() []string {
+// Tables lists tables of the database and reads their columns,
+// a table which columns can't be read is still listed and its error is returned by TableError
+func (i *DBInfo) Tables() ([]string, error) {
+	return i.SelectTables(nil, nil)
+}
+
+// SelectTables lists the tables given in only, or all tables of the database except those in skip when only is empty,
+// and reads their columns. A table in only which the database does not have is an error
+func (i *DBInfo) SelectTables(only, skip []string) ([]string, error) {
+	all, err := i.listTables()
+	if err != nil {
+		return nil, err
+	}
+	tables, err := selectTables(all, only, skip)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		i.tableColumnTypes[table], i.tableColumnDefs[table], i.tableErrors[table] = i.tableColumns(table)
+	}
+	return tables, nil
+}
+
+func (i *DBInfo) listTables() ([]string, error) {
 	result, err := i.conn.Query("SHOW FULL TABLES WHERE Table_type LIKE 'BASE TABLE'")
 	if err != nil {
-		log.Fatalf("Error listing tables: %s", err)
+		return nil, fmt.Errorf("error listing tables: %s", err)
 	}
-	defer result.Close()
 	var tables []string
 	for result.Next() {
 		var table, kind string
 		if err := result.Scan(&table, &kind); err != nil {
-			log.Fatalf("Error scanning: %s", err)
+			result.Close()
+			return nil, fmt.Errorf("error scanning: %s", err)
 		}
 		tables = append(tables, table)
-		i.tableColumnTypes[table] = i.tableColumns(table)
 	}
-	return tables
+	if err := result.Close(); err != nil {
+		return nil, fmt.Errorf("error listing tables: %s", err)
+	}
+	return tables, nil
+}
+
+// selectTables returns tables of only in their order, or all tables except skipped ones when only is empty
+func selectTables(all, only, skip []string) ([]string, error) {
+	exists := make(map[string]bool, len(all))
+	for _, table := range all {
+		exists[table] = true
+	}
+	if len(only) > 0 {
+		var missing []string
+		for _, table := range only {
+			if !exists[table] {
+				missing = append(missing, table)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("no such tables: %s", strings.Join(missing, ", "))
+		}
+		return only, nil
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, table := range skip {
+		skipped[table] = true
+	}
+	var tables []string
+	for _, table := range all {
+		if !skipped[table] {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
+
+// TableError returns the error of reading the table columns, if there was one
+func (i *DBInfo) TableError(tableName string) error {
+	return i.tableErrors[tableName]
+}
+
+// ServerVersion returns the version string of the server
+func (i *DBInfo) ServerVersion() string {
+	var version string
+	if err := i.conn.QueryRow("SELECT VERSION()").Scan(&version); err != nil {
+		log.Printf("Could not get server version: %s", err)
+	}
+	return version
+}
+
+// TableColumns returns names and SQL types of the table columns
+func (i *DBInfo) TableColumns(tableName string) []Column {
+	return i.tableColumnDefs[tableName]
+}
+
+// PrimaryKey returns columns of the table primary key in index order, empty if there's none
+func (i *DBInfo) PrimaryKey(tableName string) []Column {
+	result, err := i.conn.Queryx("SHOW KEYS FROM `" + tableName + "` WHERE `Key_name` = 'PRIMARY'")
+	if err != nil {
+		log.Printf("Error getting table %q primary key: %s", tableName, err)
+		return nil
+	}
+	defer result.Close()
+	var names []string
+	for result.Next() {
+		fields := make(map[string]interface{})
+		if err := result.MapScan(fields); err != nil {
+			log.Printf("Error scanning: %s", err)
+			return nil
+		}
+		if name, ok := fields["Column_name"].([]byte); ok {
+			names = append(names, string(name))
+		}
+	}
+	var pk []Column
+	for _, name := range names {
+		for _, column := range i.tableColumnDefs[tableName] {
+			if column.Name == name {
+				pk = append(pk, column)
+			}
+		}
+	}
+	return pk
+}
+
+// TableSize returns estimated number of rows and data length of the table
+func (i *DBInfo) TableSize(tableName string) (rows, bytes int64) {
+	err := i.conn.QueryRow(
+		"SELECT IFNULL(`table_rows`, 0), IFNULL(`data_length`, 0) FROM `information_schema`.`tables` WHERE `table_schema` = DATABASE() AND `table_name` = ?",
+		tableName,
+	).Scan(&rows, &bytes)
+	if err != nil {
+		log.Printf("Error getting table %q size: %s", tableName, err)
+	}
+	return rows, bytes
+}
+
+// Column kinds define how values are encoded in data files:
+// strings as JSON strings, numbers as they are, binary values as base64 JSON strings
+// and bit values as unsigned integers
+const (
+	KindString  = "string"
+	KindNumeric = "numeric"
+	KindBinary  = "binary"
+	KindBit     = "bit"
+)
+
+var columnKinds = map[string]string{
+	// String ///
+	"char":       KindString,
+	"varchar":    KindString,
+	"tinytext":   KindString,
+	"text":       KindString,
+	"mediumtext": KindString,
+	"longtext":   KindString,
+	"enum":       KindString,
+	"set":        KindString,
+	"json":       KindString,
+	"inet4":      KindString, // MariaDB
+	"inet6":      KindString, // MariaDB
+	"uuid":       KindString, // MariaDB
+	// Temporal, may be zero or negative so kept as strings ///
+	"date":      KindString,
+	"datetime":  KindString,
+	"timestamp": KindString,
+	"time":      KindString,
+	// Numeric ///
+	"tinyint":   KindNumeric,
+	"smallint":  KindNumeric,
+	"mediumint": KindNumeric,
+	"int":       KindNumeric,
+	"integer":   KindNumeric,
+	"bigint":    KindNumeric,
+	"decimal":   KindNumeric,
+	"numeric":   KindNumeric,
+	"dec":       KindNumeric,
+	"fixed":     KindNumeric,
+	"float":     KindNumeric,
+	"double":    KindNumeric,
+	"real":      KindNumeric,
+	"year":      KindNumeric,
+	// Binary ///
+	"binary":     KindBinary,
+	"varbinary":  KindBinary,
+	"tinyblob":   KindBinary,
+	"blob":       KindBinary,
+	"mediumblob": KindBinary,
+	"longblob":   KindBinary,
+	"vector":     KindBinary, // MariaDB
+	// Spatial, in MySQL internal format: 4 bytes SRID followed by WKB ///
+	"geometry":           KindBinary,
+	"point":              KindBinary,
+	"linestring":         KindBinary,
+	"polygon":            KindBinary,
+	"multipoint":         KindBinary,
+	"multilinestring":    KindBinary,
+	"multipolygon":       KindBinary,
+	"geometrycollection": KindBinary,
+	"geomcollection":     KindBinary,
+	// Bit ///
+	"bit": KindBit,
+}
+
+// ColumnKind classifies the SQL type as reported by SHOW COLUMNS or written in CREATE TABLE
+func ColumnKind(sqlType string) (string, error) {
+	if kind, ok := columnKinds[baseType(sqlType)]; ok {
+		return kind, nil
+	}
+	return "", fmt.Errorf("unsupported type %q", sqlType)
+}
+
+// IsIntegerType tells if the SQL type as reported by SHOW COLUMNS is an integer one
+func IsIntegerType(sqlType string) bool {
+	switch baseType(sqlType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// baseType returns lower case type name without length and attributes
+func baseType(sqlType string) string {
+	base := strings.ToLower(strings.TrimSpace(sqlType))
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// ReplicationStatus reads binary log coordinates using q, which should be the
+// connection holding the lock, so they match the dumped data
+func (i *DBInfo) ReplicationStatus(q sqlx.QueryerContext) ReplicationStatus {
+	var status ReplicationStatus
+	i.getMasterStatus(q)
+	if i.isMaster {
+		master := i.masterStatus
+		status.Master = &master
+	}
+	status.Slave = i.getSlaveStatus(q)
+	return status
+}
+
+// queryStatus runs the first statement the server knows, statements are renamed by newer versions
+// and the old ones removed, e.g. SHOW MASTER STATUS by MySQL 8.4
+func queryStatus(q sqlx.QueryerContext, statements ...string) (*sqlx.Rows, error) {
+	var err error
+	for _, statement := range statements {
+		var result *sqlx.Rows
+		if result, err = q.QueryxContext(context.Background(), statement); err == nil {
+			return result, nil
+		}
+	}
+	return nil, err
 }
 
-func (i *DBInfo) getMasterStatus() {
-	if result, err := i.conn.Queryx("SHOW MASTER STATUS"); err != nil {
+func (i *DBInfo) getMasterStatus(q sqlx.QueryerContext) {
+	if result, err := queryStatus(q, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS"); err != nil {
 		log.Printf("Could not get master status: %s", err)
 	} else {
 		defer result.Close()
@@ -75,6 +343,50 @@ func (i *DBInfo) getMasterStatus() {
 	}
 }
 
+func (i *DBInfo) getSlaveStatus(q sqlx.QueryerContext) []SlaveStatus {
+	result, err := queryStatus(q, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+	if err != nil {
+		log.Printf("Could not get slave status: %s", err)
+		return nil
+	}
+	defer result.Close()
+	var channels []SlaveStatus
+	for result.Next() {
+		fields := make(map[string]interface{})
+		if err := result.MapScan(fields); err != nil {
+			log.Printf("Error scanning slave status: %s", err)
+			continue
+		}
+		channels = append(channels, slaveStatus(fields))
+	}
+	return channels
+}
+
+// slaveStatus takes the position from a row of SHOW REPLICA STATUS, or of SHOW SLAVE STATUS with the old column names
+func slaveStatus(fields map[string]interface{}) SlaveStatus {
+	str := func(names ...string) string {
+		for _, name := range names {
+			if v, ok := fields[name].([]byte); ok {
+				return string(v)
+			}
+		}
+		return ""
+	}
+	num := func(names ...string) int {
+		n, _ := strconv.Atoi(str(names...))
+		return n
+	}
+	return SlaveStatus{
+		ChannelName:        str("Channel_Name"),
+		MasterHost:         str("Source_Host", "Master_Host"),
+		MasterPort:         num("Source_Port", "Master_Port"),
+		RelayMasterLogFile: str("Relay_Source_Log_File", "Relay_Master_Log_File"),
+		ExecMasterLogPos:   num("Exec_Source_Log_Pos", "Exec_Master_Log_Pos"),
+		RetrievedGtidSet:   str("Retrieved_Gtid_Set"),
+		ExecutedGtidSet:    str("Executed_Gtid_Set"),
+	}
+}
+
 func (i *DBInfo) TableColumnType(tableName string, col int) string {
 	if table, ok := i.tableColumnTypes[tableName]; ok {
 		if col < len(table) {
@@ -87,84 +399,33 @@ func (i *DBInfo) TableColumnType(tableName string, col int) string {
 	return ""
 }
 
-func (i *DBInfo) tableColumns(tableName string) []string {
-	result, err := i.conn.Queryx("SHOW COLUMNS FROM " + tableName)
+func (i *DBInfo) tableColumns(tableName string) ([]string, []Column, error) {
+	result, err := i.conn.Queryx("SHOW COLUMNS FROM `" + tableName + "`")
 	if err != nil {
-		log.Fatalf("Error getting table %q columns: %s", tableName, err)
+		return nil, nil, fmt.Errorf("error getting columns: %s", err)
 	}
 	defer result.Close()
-	var cTypes []string
+	var (
+		cTypes []string
+		cDefs  []Column
+	)
 	for result.Next() {
 		var (
 			fields []interface{}
 			err    error
 		)
 		if fields, err = result.SliceScan(); err != nil {
-			log.Fatalf("Error scanning: %s", err)
+			return nil, nil, fmt.Errorf("error scanning columns: %s", err)
 		}
-		kind := string(fields[1].([]uint8))
-		switch {
-		// TODO bit type support?
-		// String ///
-		case strings.HasPrefix(kind, "varchar"):
-			fallthrough
-		case strings.HasPrefix(kind, "char"):
-			fallthrough
-		case strings.HasPrefix(kind, "text"):
-			fallthrough
-		case strings.HasPrefix(kind, "tinytext"):
-			fallthrough
-		case strings.HasPrefix(kind, "mediumtext"):
-			fallthrough
-		case strings.HasPrefix(kind, "longtext"):
-			fallthrough
-		case strings.HasPrefix(kind, "set"):
-			fallthrough
-		case strings.HasPrefix(kind, "enum"):
-			fallthrough
-		case strings.HasPrefix(kind, "date"):
-			fallthrough
-		case strings.HasPrefix(kind, "text"):
-			cTypes = append(cTypes, "string")
-		// Numeric ///
-		case strings.HasPrefix(kind, "int"):
-			fallthrough
-		case strings.HasPrefix(kind, "smallint"):
-			fallthrough
-		case strings.HasPrefix(kind, "tinyint"):
-			fallthrough
-		case strings.HasPrefix(kind, "mediumint"):
-			fallthrough
-		case strings.HasPrefix(kind, "bigint"):
-			fallthrough
-		case strings.HasPrefix(kind, "decimal"):
-			fallthrough
-		case strings.HasPrefix(kind, "numeric"):
-			fallthrough
-		case strings.HasPrefix(kind, "timestamp"):
-			fallthrough
-		case strings.HasPrefix(kind, "float"):
-			fallthrough
-		case strings.HasPrefix(kind, "double"):
-			cTypes = append(cTypes, "numeric")
-		// Binary ///
-		case strings.HasPrefix(kind, "binary"):
-			fallthrough
-		case strings.HasPrefix(kind, "varbinary"):
-			fallthrough
-		case strings.HasPrefix(kind, "blob"):
-			fallthrough
-		case strings.HasPrefix(kind, "tinyblob"):
-			fallthrough
-		case strings.HasPrefix(kind, "mediumblob"):
-			fallthrough
-		case strings.HasPrefix(kind, "longblob"):
-			cTypes = append(cTypes, "binary")
-		default:
-			log.Fatalf("Unsupported type %q", kind)
+		sqlType := string(fields[1].([]uint8))
+		cDefs = append(cDefs, Column{Name: string(fields[0].([]uint8)), Type: sqlType})
+		kind, err := ColumnKind(sqlType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %q: %s", fields[0], err)
 		}
+		cTypes = append(cTypes, kind)
 	}
-	return cTypes
+	return cTypes, cDefs, result.Err()
 }
 
 func (i *DBInfo) HasBackupLock() bool {
@@ -0,0 +1,91 @@
+package db_info
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnKind(t *testing.T) {
+	cases := map[string]string{
+		"int(10) unsigned":             KindNumeric,
+		"bigint(20) unsigned zerofill": KindNumeric,
+		"float unsigned":               KindNumeric,
+		"double precision":             KindNumeric,
+		"decimal(20,6)":                KindNumeric,
+		"year(4)":                      KindNumeric,
+		"varchar(60)":                  KindString,
+		"enum('N','Y')":                KindString,
+		"set('a','b')":                 KindString,
+		"json":                         KindString,
+		"time(6)":                      KindString,
+		"timestamp":                    KindString,
+		"datetime(3)":                  KindString,
+		"varbinary(16)":                KindBinary,
+		"longblob":                     KindBinary,
+		"point":                        KindBinary,
+		"geometry":                     KindBinary,
+		"polygon":                      KindBinary,
+		"bit(1)":                       KindBit,
+		"BIT(64)":                      KindBit,
+		"inet6":                        KindString,
+	}
+	for sqlType, expected := range cases {
+		kind, err := ColumnKind(sqlType)
+		if err != nil {
+			t.Errorf("%q: %s", sqlType, err)
+		} else if kind != expected {
+			t.Errorf("%q: expected %q, got %q", sqlType, expected, kind)
+		}
+	}
+	if _, err := ColumnKind("hologram"); err == nil {
+		t.Error("Expected error for unknown type")
+	}
+}
+
+func TestIsIntegerType(t *testing.T) {
+	for _, sqlType := range []string{"int(11)", "bigint(20) unsigned", "tinyint(1)"} {
+		if !IsIntegerType(sqlType) {
+			t.Errorf("%q expected to be integer", sqlType)
+		}
+	}
+	for _, sqlType := range []string{"varchar(10)", "decimal(10,2)", "binary(16)"} {
+		if IsIntegerType(sqlType) {
+			t.Errorf("%q expected not to be integer", sqlType)
+		}
+	}
+}
+
+func TestSelectTables(t *testing.T) {
+	all := []string{"log", "orders", "users"}
+	tables, err := selectTables(all, []string{"users", "orders"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || tables[0] != "users" || tables[1] != "orders" {
+		t.Errorf("Got %v", tables)
+	}
+	if _, err := selectTables(all, []string{"users", "gone", "view"}, nil); err == nil || err.Error() != "no such tables: gone, view" {
+		t.Errorf("Expected missing tables error, got %v", err)
+	}
+	tables, err = selectTables(all, nil, []string{"log", "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || tables[0] != "orders" || tables[1] != "users" {
+		t.Errorf("Got %v", tables)
+	}
+}
+
+func TestSlaveStatus(t *testing.T) {
+	expected := SlaveStatus{MasterHost: "primary", MasterPort: 3306, RelayMasterLogFile: "binlog.000042", ExecMasterLogPos: 154}
+	for _, fields := range []map[string]interface{}{
+		// SHOW REPLICA STATUS of MySQL 8.0.22 and later
+		{"Source_Host": []byte("primary"), "Source_Port": []byte("3306"), "Relay_Source_Log_File": []byte("binlog.000042"), "Exec_Source_Log_Pos": []byte("154")},
+		// SHOW SLAVE STATUS of older versions
+		{"Master_Host": []byte("primary"), "Master_Port": []byte("3306"), "Relay_Master_Log_File": []byte("binlog.000042"), "Exec_Master_Log_Pos": []byte("154")},
+	} {
+		if status := slaveStatus(fields); !reflect.DeepEqual(status, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, status)
+		}
+	}
+}
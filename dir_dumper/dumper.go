@@ -1,23 +1,31 @@
 package dir_dumper
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"net"
-	"net/url"
 	"os"
-	"os/exec"
-	"os/user"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/BrightLocal/MySQLBackup/compression"
+	"github.com/BrightLocal/MySQLBackup/db_info"
+	"github.com/BrightLocal/MySQLBackup/encryption"
+	"github.com/BrightLocal/MySQLBackup/hooks"
+	"github.com/BrightLocal/MySQLBackup/manifest"
+	"github.com/BrightLocal/MySQLBackup/metrics"
+	"github.com/BrightLocal/MySQLBackup/progress"
+	"github.com/BrightLocal/MySQLBackup/stats"
+	"github.com/BrightLocal/MySQLBackup/storage"
 	"github.com/BrightLocal/MySQLBackup/table_dumper"
-	"github.com/dsnet/compress/bzip2"
+	"github.com/BrightLocal/MySQLBackup/worker_pool"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
+	"github.com/pkg/errors"
 )
 
 type DumpResult interface {
@@ -26,158 +34,593 @@ type DumpResult interface {
 	Duration() time.Duration
 }
 
+type Config interface {
+	table_dumper.Config
+	ReplicationStatus(sqlx.QueryerContext) db_info.ReplicationStatus
+	ServerVersion() string
+	TableColumns(string) []db_info.Column
+	PrimaryKey(string) []db_info.Column
+	TableSize(string) (int64, int64)
+	TableError(string) error
+}
+
 type DirDumper struct {
-	dsn           string
-	dir           string
-	config        table_dumper.Config
-	conn          *sqlx.DB
-	totalRows     int
-	totalBytes    int
-	totalDuration time.Duration
-	runAfter      string
-	withHeader    bool
+	ctx        context.Context
+	dsn        string
+	storage    storage.Backend
+	config     Config
+	conn       *sqlx.DB
+	snapshots  chan *sqlx.Conn
+	lockMode   string
+	metadata   metadata
+	manifest   *manifest.Manifest
+	stats      *stats.Stats
+	progress   *progress.Tracker
+	metrics    *metrics.Metrics
+	hooks      *hooks.Hooks
+	withHeader bool
+	codec      *compression.Codec
+	level      int
+	encrypter  *encryption.Encrypter
+	chunkRows  int64
+	resume     bool
+	resumed    bool
+	mu         sync.Mutex
+	pending    map[string]int  // chunks of tables left to dump
+	failed     map[string]bool // tables table-failed hooks ran for
+	manifestMu sync.Mutex
+}
+
+// snapshot is when a run started its snapshots and binlog coordinates matching them
+type snapshot struct {
+	Started     time.Time                 `json:"started"`
+	Replication db_info.ReplicationStatus `json:"replication"`
+}
+
+// metadata describes the snapshot the backup was taken from, tables dumped by resumed runs are from their own snapshots
+type metadata struct {
+	snapshot
+	Resumed []snapshot `json:"resumed,omitempty"`
 }
 
-const fileSuffix = ".csjson.bz2"
+const (
+	schemaFileName   = "schema.sql"
+	metadataFileName = "metadata.json"
+)
 
-func NewDirDumper(dir string, config table_dumper.Config) *DirDumper {
+// NewDirDumper returns the dumper into the storage, the storage is to be closed by the caller
+func NewDirDumper(backend storage.Backend, config Config) *DirDumper {
+	codec, _ := compression.ByName("bzip2")
 	return &DirDumper{
-		dir:    dir,
-		config: config,
+		ctx:      context.Background(),
+		storage:  backend,
+		config:   config,
+		codec:    codec,
+		lockMode: LockAuto,
+		stats:    stats.New(),
+		progress: progress.New(),
+		metrics:  metrics.New("tabledumper"),
+		hooks:    hooks.New(1, 0),
+		pending:  make(map[string]int),
+		failed:   make(map[string]bool),
 	}
 }
 
+// WithContext sets the context which cancels locking and splitting, a cancelled dump is marked interrupted in the manifest
+func (d *DirDumper) WithContext(ctx context.Context) *DirDumper {
+	d.ctx = ctx
+	return d
+}
+
+// WithLock sets how the server is locked while snapshots are started, one of Lock* modes
+func (d *DirDumper) WithLock(mode string) *DirDumper {
+	d.lockMode = mode
+	return d
+}
+
+// WithCompression sets the codec and its level for data files, level 0 is the codec default
+func (d *DirDumper) WithCompression(codec *compression.Codec, level int) *DirDumper {
+	d.codec = codec
+	d.level = level
+	return d
+}
+
+// WithEncryption makes data files encrypted after compression, nil leaves them unencrypted
+func (d *DirDumper) WithEncryption(encrypter *encryption.Encrypter) *DirDumper {
+	d.encrypter = encrypter
+	return d
+}
+
 func (d *DirDumper) WithHeader(withHeader bool) *DirDumper {
 	d.withHeader = withHeader
 	return d
 }
 
+// WithProgress sets the tracker to report progress of dumped tables to
+func (d *DirDumper) WithProgress(tracker *progress.Tracker) *DirDumper {
+	d.progress = tracker
+	return d
+}
+
+// WithMetrics sets the metrics to update while dumping
+func (d *DirDumper) WithMetrics(m *metrics.Metrics) *DirDumper {
+	d.metrics = m
+	return d
+}
+
+// Total returns totals of all dumped tables
+func (d *DirDumper) Total() stats.Table {
+	return d.stats.Total()
+}
+
+// WithChunkRows makes tables larger than rows to be dumped in primary key ranges of about that size, 0 disables it
+func (d *DirDumper) WithChunkRows(rows int64) *DirDumper {
+	d.chunkRows = rows
+	return d
+}
+
+// WithResume makes the dumper keep tables completed by the previous run according to its manifest,
+// must be called before Connect
+func (d *DirDumper) WithResume(resume bool) *DirDumper {
+	d.resume = resume
+	return d
+}
+
+// WithHooks sets hooks run on file-done, table-done and table-failed events, the caller waits for them
+func (d *DirDumper) WithHooks(h *hooks.Hooks) *DirDumper {
+	d.hooks = h
+	return d
+}
+
+// RunAfter sets the command run after a file is dumped, it's the file-done hook
 func (d *DirDumper) RunAfter(cmd string) *DirDumper {
-	d.runAfter = cmd
+	d.hooks.On(hooks.FileDone, cmd)
 	return d
 }
 
-func (d *DirDumper) Connect(dsn string) *DirDumper {
+// Connect takes a brief global lock, starts a consistent snapshot on a dedicated connection
+// for every stream and records binlog coordinates before releasing the lock
+func (d *DirDumper) Connect(dsn string, streams int) *DirDumper {
 	d.dsn = dsn
-	var err error
-	d.conn, err = sqlx.Connect("mysql", d.dsn)
+	config, err := mysql.ParseDSN(d.dsn)
+	if err != nil {
+		log.Fatalf("Error parsing DSN: %s", err)
+	}
+	// chunk bounds are interpolated into queries, a prepared statement would return rows in binary protocol
+	config.InterpolateParams = true
+	d.conn, err = sqlx.Connect("mysql", config.FormatDSN())
+	if err != nil {
+		log.Fatalf("Error connecting: %s", err)
+	}
+	ctx := d.ctx
+	// the previous run is read before locking, so remote storage isn't waited for while writes are blocked
+	if d.resume {
+		if err := d.loadManifest(); err != nil {
+			log.Fatalf("Error reading manifest to resume: %s", err)
+		}
+	}
+	lockConn, err := d.conn.Connx(ctx)
 	if err != nil {
 		log.Fatalf("Error connecting: %s", err)
 	}
-	_, err = d.conn.Exec("START TRANSACTION WITH CONSISTENT SNAPSHOT")
+	defer lockConn.Close()
+	var version string
+	mode := d.lockMode
+	if d.config != nil {
+		version = d.config.ServerVersion()
+		mode = chooseLockMode(mode, d.config.HasBackupLock(), version)
+	} else {
+		mode = chooseLockMode(mode, false, version)
+	}
+	locked := time.Now()
+	unlock, err := lock(ctx, lockConn, mode, version)
 	if err != nil {
-		log.Fatalf("Error starting transaction: %s", err)
+		log.Fatalf("Error locking for backup: %s", err)
 	}
-	if d.config != nil && d.config.HasBackupLock() {
-		if _, err := d.conn.Exec("LOCK TABLES FOR BACKUP"); err != nil {
-			log.Fatalf("Error locking tables for backup: %s", err)
+	d.snapshots = make(chan *sqlx.Conn, streams)
+	for i := 0; i < streams; i++ {
+		conn, err := d.conn.Connx(ctx)
+		if err != nil {
+			log.Fatalf("Error connecting: %s", err)
 		}
-		if _, err := d.conn.Exec("LOCK BINLOG FOR BACKUP"); err != nil {
-			log.Fatalf("Error locking binlog for backup: %s", err)
+		if _, err := conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			log.Fatalf("Error setting isolation level: %s", err)
 		}
+		if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			log.Fatalf("Error starting transaction: %s", err)
+		}
+		d.snapshots <- conn
+	}
+	current := snapshot{Started: time.Now()}
+	if d.config != nil {
+		current.Replication = d.config.ReplicationStatus(lockConn)
+	}
+	if err := unlock(); err != nil {
+		log.Fatalf("Error unlocking: %s", err)
+	}
+	held := time.Now().Sub(locked)
+	d.metrics.LockHeld(held)
+	log.Printf("Started %d snapshots using %q lock held for %s", streams, mode, held)
+	if d.resumed {
+		d.manifest.Resumed = append(d.manifest.Resumed, current.Started)
+		d.metadata.Resumed = append(d.metadata.Resumed, current)
+	} else {
+		d.manifest = manifest.New(version, current.Started)
+		d.metadata.snapshot = current
 	}
 	return d
 }
 
-func (d *DirDumper) Dump(tableName interface{}) {
-	name := tableName.(string)
-	td := table_dumper.NewTableDumper(d.dsn, name, d.config).WithHeader(d.withHeader)
-	fileName := name + fileSuffix
-	writer, err := d.getWriter(fileName)
-	if err != nil {
-		log.Fatalf("Error getting writer: %s", err)
+// Close releases snapshot connections
+func (d *DirDumper) Close() {
+	close(d.snapshots)
+	for conn := range d.snapshots {
+		conn.Close()
 	}
-	compressor, _ := bzip2.NewWriter(writer, &bzip2.WriterConfig{Level: bzip2.BestCompression})
-	dumpResult, err := td.Run(compressor, d.conn)
+	d.conn.Close()
+}
+
+// snapshot takes a connection with a started snapshot, it must be given back with release
+func (d *DirDumper) snapshot() *sqlx.Conn {
+	return <-d.snapshots
+}
+
+func (d *DirDumper) release(conn *sqlx.Conn) {
+	d.snapshots <- conn
+}
+
+// DumpSchema writes CREATE TABLE statements of the tables into schema.sql and returns tables it has written,
+// it must be called before Dump to read them from the same snapshot. A table which create statement
+// can't be read, e.g. dropped since it was listed, is counted as failed and left out
+func (d *DirDumper) DumpSchema(tables []string) ([]string, error) {
+	writer, err := d.getWriter(schemaFileName)
 	if err != nil {
-		log.Printf("Error running worker: %s", err)
-		compressor.Close()
-		writer.Close()
-		return
+		return nil, err
 	}
-	d.totalBytes += dumpResult.Bytes()
-	d.totalRows += dumpResult.Rows()
-	d.totalDuration += dumpResult.Duration()
-	if err := compressor.Close(); err != nil {
-		log.Printf("Error closing compressor: %s", err)
+	conn := d.snapshot()
+	defer d.release(conn)
+	var dumped []string
+	for _, name := range tables {
+		var table, create string
+		if err := conn.QueryRowxContext(d.ctx, "SHOW CREATE TABLE `"+name+"`").Scan(&table, &create); err != nil {
+			if d.ctx.Err() != nil {
+				writer.Abort()
+				return nil, d.ctx.Err()
+			}
+			d.fail(name, errors.Wrap(err, "error getting create statement"))
+			continue
+		}
+		if _, err := fmt.Fprintf(writer, "--\n-- Table structure for table `%s`\n--\n\n%s;\n\n", table, create); err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		dumped = append(dumped, name)
 	}
-	if err := writer.Close(); err != nil {
-		log.Printf("Error closing file %q: %s", fileName, err)
+	return dumped, writer.Close()
+}
+
+// loadManifest takes the manifest of the previous run, keeping only tables it completed
+func (d *DirDumper) loadManifest() error {
+	reader, err := d.storage.Open(manifest.FileName)
+	if os.IsNotExist(err) {
+		log.Print("No manifest of the previous run found, dumping all tables")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+	previous, err := manifest.Read(reader)
+	if err != nil {
+		return err
 	}
-	if command := d.prepareCommand(fileName); command != "" {
-		if err := exec.Command("/bin/sh", "-c", command).Start(); err != nil {
-			log.Printf("Error starting command %q: %s", command, err)
-		} else {
-			log.Printf("Started command %q", command)
+	previous.DropIncomplete()
+	previous.Interrupted = false
+	if err := d.loadMetadata(); err != nil {
+		return err
+	}
+	if d.encrypter != nil {
+		if err := d.loadKey(); err != nil {
+			return err
 		}
 	}
+	d.manifest = previous
+	d.resumed = true
+	log.Printf("Resuming the dump started at %s, %d tables are complete", previous.Started, len(previous.Tables))
+	log.Print("Warning: tables dumped by different runs are not from the same snapshot")
+	return nil
 }
 
-func (d *DirDumper) getWriter(fileName string) (io.WriteCloser, error) {
-	if strings.HasPrefix(d.dir, "sftp://") {
-		where, err := url.Parse(d.dir)
-		if err != nil {
-			return nil, err
-		}
-		if where.User == nil || where.User.Username() == "" {
-			// Try to figure out user name
-			if userName := os.Getenv("USER"); userName != "" {
-				where.User = url.UserPassword(userName, "")
-			} else {
-				if currentUser, err := user.Current(); err == nil {
-					where.User = url.UserPassword(currentUser.Username, "")
-				} else {
-					return nil, errors.New("user name expected")
-				}
-			}
-		}
-		if where.Path == "" {
-			return nil, errors.New("path expected")
-		}
-		if where.Host == "" {
-			return nil, errors.New("host name is empty expected")
-		}
-		if where.Port() == "" {
-			where.Host = where.Host + ":22"
+// loadMetadata takes snapshots of the previous runs, so the coordinates of every run are kept
+func (d *DirDumper) loadMetadata() error {
+	reader, err := d.storage.Open(metadataFileName)
+	if os.IsNotExist(err) {
+		log.Print("Warning: no metadata of the previous run found, its binlog coordinates are unknown")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return json.NewDecoder(reader).Decode(&d.metadata)
+}
+
+// loadKey takes the data key of the previous run, so all files of the dump are encrypted by the same key
+func (d *DirDumper) loadKey() error {
+	reader, err := d.storage.Open(encryption.KeyFileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer reader.Close()
+	key, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return d.encrypter.UseKey(key)
+}
+
+// Completed tells if the table was completed by the previous run when resuming
+func (d *DirDumper) Completed(tableName string) bool {
+	return d.resumed && d.manifest.Table(tableName) != nil
+}
+
+// WriteMetadata writes binlog coordinates of the snapshot into metadata.json,
+// a resumed dump keeps the coordinates of the first run and adds those of the resumed run
+func (d *DirDumper) WriteMetadata() error {
+	if err := d.writeKey(); err != nil {
+		return err
+	}
+	writer, err := d.getWriter(metadataFileName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(d.metadata); err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+// writeKey writes the data key wrapped by the passphrase, files encrypted for recipients have no key file
+func (d *DirDumper) writeKey() error {
+	if d.encrypter == nil || d.encrypter.Key() == nil {
+		return nil
+	}
+	writer, err := d.getWriter(encryption.KeyFileName)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(d.encrypter.Key()); err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+// WriteManifest writes the list of dumped files into manifest.json, it must be called after all tables are dumped
+func (d *DirDumper) WriteManifest() error {
+	d.manifest.Finished = time.Now()
+	d.manifest.Interrupted = d.ctx.Err() != nil
+	return d.writeManifest()
+}
+
+// writeManifest writes the manifest as it is, it's rewritten every time a table is complete so the dump can be resumed
+func (d *DirDumper) writeManifest() error {
+	d.manifestMu.Lock()
+	defer d.manifestMu.Unlock()
+	writer, err := d.getWriter(manifest.FileName)
+	if err != nil {
+		return err
+	}
+	if err := d.manifest.Write(writer); err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Close()
+}
+
+// Split returns chunks of the table to be dumped, it must be called before Dump to read them from the same snapshot
+func (d *DirDumper) Split(tableName string) ([]table_dumper.Chunk, error) {
+	if d.config != nil {
+		if err := d.config.TableError(tableName); err != nil {
+			return nil, d.fail(tableName, err)
 		}
-		return d.getSFTPWriter(fileName, where)
 	}
-	return d.getFileWriter(fileName)
+	chunks, err := d.split(tableName)
+	if err != nil {
+		return nil, d.fail(tableName, errors.Wrap(err, "error splitting into chunks"))
+	}
+	var rows, bytes int64
+	if d.config != nil {
+		rows, bytes = d.config.TableSize(tableName)
+	}
+	d.progress.Expect(tableName, len(chunks), rows, bytes)
+	d.mu.Lock()
+	d.pending[tableName] = len(chunks)
+	d.mu.Unlock()
+	for i := range chunks {
+		chunks[i].Bytes = bytes / int64(len(chunks))
+	}
+	return chunks, nil
 }
 
-func (d *DirDumper) getSFTPWriter(fileName string, where *url.URL) (io.WriteCloser, error) {
-	var authenticationMethods []ssh.AuthMethod
-	if aConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		authenticationMethods = append(authenticationMethods, ssh.PublicKeysCallback(agent.NewClient(aConn).Signers))
+func (d *DirDumper) split(tableName string) ([]table_dumper.Chunk, error) {
+	if d.chunkRows <= 0 || d.config == nil {
+		return []table_dumper.Chunk{{Table: tableName}}, nil
 	}
-	conn, err := ssh.Dial("tcp", where.Host, &ssh.ClientConfig{
-		User:            where.User.Username(),
-		Auth:            authenticationMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	})
+	rows, _ := d.config.TableSize(tableName)
+	var pk []string
+	integer := false
+	for i, column := range d.config.PrimaryKey(tableName) {
+		if i == 0 {
+			integer = db_info.IsIntegerType(column.Type)
+		}
+		pk = append(pk, column.Name)
+	}
+	conn := d.snapshot()
+	defer d.release(conn)
+	chunks, err := table_dumper.Split(d.ctx, conn, tableName, pk, integer, rows, d.chunkRows)
 	if err != nil {
 		return nil, err
 	}
-	f, err := sftp.NewClient(conn)
+	if len(chunks) > 1 {
+		log.Printf("Table %q will be dumped in %d chunks", tableName, len(chunks))
+	}
+	return chunks, nil
+}
+
+// Dump dumps a chunk of a table into its data file, a partially written file is removed on error
+func (d *DirDumper) Dump(ctx context.Context, job worker_pool.Job) error {
+	chunk := job.(table_dumper.Chunk)
+	name := chunk.Table
+	d.metrics.StreamStarted()
+	defer d.metrics.StreamFinished()
+	td := table_dumper.NewTableDumper(d.dsn, name, d.config).
+		WithHeader(d.withHeader).
+		WithChunk(chunk).
+		WithProgress(func(rows, bytes int) {
+			d.progress.Add(name, int64(rows), int64(bytes))
+			d.metrics.Add(name, rows, bytes)
+		})
+	fileName := chunk.FileName() + d.codec.FileSuffix()
+	encryptionName := ""
+	if d.encrypter != nil {
+		fileName += encryption.Suffix
+		encryptionName = encryption.Name
+	}
+	writer, err := d.getWriter(fileName)
 	if err != nil {
-		return nil, err
+		return d.fail(name, errors.Wrapf(err, "error creating file %q", fileName))
+	}
+	hashWriter := manifest.NewHashWriter(writer)
+	// data is compressed, then encrypted, the checksum is of the stored file
+	var encryptor io.WriteCloser = nopCloser{hashWriter}
+	if d.encrypter != nil {
+		if encryptor, err = d.encrypter.NewWriter(hashWriter); err != nil {
+			writer.Abort()
+			return d.fail(name, errors.Wrap(err, "error creating encryptor"))
+		}
+	}
+	compressor, err := d.codec.NewWriter(encryptor, d.level)
+	if err != nil {
+		writer.Abort()
+		return d.fail(name, errors.Wrapf(err, "error creating %s compressor", d.codec.Name))
+	}
+	conn := d.snapshot()
+	dumpResult, err := td.Run(ctx, compressor, conn)
+	d.release(conn)
+	if err != nil {
+		compressor.Close()
+		writer.Abort()
+		return d.fail(name, errors.Wrapf(err, "error dumping %s", chunk))
+	}
+	if err := compressor.Close(); err != nil {
+		writer.Abort()
+		return d.fail(name, errors.Wrapf(err, "error closing compressor of file %q", fileName))
 	}
-	return f.Create(where.Path + "/" + fileName)
+	if err := encryptor.Close(); err != nil {
+		writer.Abort()
+		return d.fail(name, errors.Wrapf(err, "error closing encryptor of file %q", fileName))
+	}
+	if err := writer.Close(); err != nil {
+		return d.fail(name, errors.Wrapf(err, "error closing file %q", fileName))
+	}
+	d.stats.Add(stats.Table{
+		Name:     name,
+		Files:    1,
+		Rows:     dumpResult.Rows(),
+		Bytes:    dumpResult.Bytes(),
+		Duration: dumpResult.Duration(),
+	})
+	var columns []manifest.Column
+	if d.config != nil {
+		for _, column := range d.config.TableColumns(name) {
+			columns = append(columns, manifest.Column{Name: column.Name, Type: column.Type})
+		}
+	}
+	d.manifest.AddFile(name, columns, manifest.File{
+		Name:            fileName,
+		Compression:     d.codec.Name,
+		Encryption:      encryptionName,
+		Rows:            dumpResult.Rows(),
+		Bytes:           int64(dumpResult.Bytes()),
+		CompressedBytes: hashWriter.Count(),
+		SHA256:          hashWriter.Sum(),
+	})
+	d.metrics.AddFile(name, int64(dumpResult.Bytes()), hashWriter.Count())
+	d.hooks.Start(hooks.FileDone, hooks.Env{
+		Table:           name,
+		File:            fileName,
+		Path:            d.storage.Path(fileName),
+		Rows:            dumpResult.Rows(),
+		Bytes:           int64(dumpResult.Bytes()),
+		CompressedBytes: hashWriter.Count(),
+		SHA256:          hashWriter.Sum(),
+		Duration:        dumpResult.Duration(),
+	})
+	d.progress.FileDone(name)
+	d.chunkDone(name)
+	return nil
+}
+
+// chunkDone marks the table complete in the manifest when all its chunks are dumped
+func (d *DirDumper) chunkDone(name string) {
+	d.mu.Lock()
+	d.pending[name]--
+	complete := d.pending[name] == 0
+	d.mu.Unlock()
+	if !complete {
+		return
+	}
+	d.manifest.Complete(name)
+	if err := d.writeManifest(); err != nil {
+		log.Printf("Warning: error writing manifest: %s", err)
+	}
+	env := hooks.Env{Table: name, Duration: d.stats.Table(name).Duration}
+	var sums []string
+	for _, file := range d.manifest.Files(name) {
+		env.Rows += file.Rows
+		env.Bytes += file.Bytes
+		env.CompressedBytes += file.CompressedBytes
+		sums = append(sums, file.SHA256)
+	}
+	// checksums of chunk files are in the order of files
+	env.SHA256 = strings.Join(sums, " ")
+	d.hooks.Start(hooks.TableDone, env)
 }
 
-func (d *DirDumper) getFileWriter(fileName string) (io.WriteCloser, error) {
-	return os.Create(d.dir + "/" + fileName)
+// fail counts the error of the table and returns it
+func (d *DirDumper) fail(name string, err error) error {
+	log.Printf("Error in table %q: %s", name, err)
+	d.stats.Fail(name, err)
+	d.metrics.Error(name, 1)
+	d.progress.Failed(name)
+	d.manifest.Fail(name)
+	d.mu.Lock()
+	first := !d.failed[name]
+	d.failed[name] = true
+	d.mu.Unlock()
+	if first {
+		d.hooks.Start(hooks.TableFailed, hooks.Env{Table: name, Error: err.Error()})
+	}
+	return errors.Wrapf(err, "table %q", name)
 }
 
-func (d DirDumper) PrintStats(streams int, totalDuration time.Duration) {
-	log.Printf("Dumped %d rows (%d bytes) using %d streams in %s (total run time %s)", d.totalRows, d.totalBytes, streams, d.totalDuration, totalDuration)
+// getWriter returns the writer of the file in the destination directory, the file appears only when the writer is closed
+func (d *DirDumper) getWriter(fileName string) (*atomicWriter, error) {
+	f, err := d.storage.Create(fileName + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicWriter{WriteCloser: f, storage: d.storage, name: fileName}, nil
 }
 
-func (d DirDumper) prepareCommand(fileName string) string {
-	if d.runAfter == "" {
-		return ""
+func (d *DirDumper) PrintStats(streams int, totalDuration time.Duration) {
+	total := d.stats.Total()
+	log.Printf("Dumped %d rows (%d bytes) using %d streams in %s (total run time %s)", total.Rows, total.Bytes, streams, total.Duration, totalDuration)
+	if err := d.stats.Print(os.Stderr); err != nil {
+		log.Printf("Error printing stats: %s", err)
 	}
-	command := strings.Replace(d.runAfter, "%FILE_NAME%", fileName, -1)
-	command = strings.Replace(command, "%FILE_PATH%", d.dir+"/"+fileName, -1)
-	return command
 }
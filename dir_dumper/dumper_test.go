@@ -0,0 +1,56 @@
+package dir_dumper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BrightLocal/MySQLBackup/db_info"
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+func TestResumedMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := snapshot{
+		Started:     time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC),
+		Replication: db_info.ReplicationStatus{Master: &db_info.MasterStatus{File: "mysql-bin.000001", Position: 4}},
+	}
+	d := NewDirDumper(storage.NewLocal(dir), nil)
+	d.metadata.snapshot = first
+	if err := d.WriteMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the resumed run keeps coordinates of the first run and adds its own
+	resumed := snapshot{
+		Started:     first.Started.Add(time.Hour),
+		Replication: db_info.ReplicationStatus{Master: &db_info.MasterStatus{File: "mysql-bin.000002", Position: 8}},
+	}
+	d = NewDirDumper(storage.NewLocal(dir), nil)
+	if err := d.loadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	d.metadata.Resumed = append(d.metadata.Resumed, resumed)
+	if err := d.WriteMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, metadataFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written metadata
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (metadata{snapshot: first, Resumed: []snapshot{resumed}}); !reflect.DeepEqual(written, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, written)
+	}
+}
@@ -0,0 +1,116 @@
+package dir_dumper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Lock modes used while snapshots are started
+const (
+	// LockAuto uses Percona backup locks when the server has them before 8.0, FLUSH TABLES WITH READ LOCK otherwise
+	LockAuto = "auto"
+	// LockFTWRL blocks all writes with FLUSH TABLES WITH READ LOCK
+	LockFTWRL = "ftwrl"
+	// LockBackup uses Percona LOCK TABLES FOR BACKUP and LOCK BINLOG FOR BACKUP. Percona Server 8.0 has no binlog lock
+	// and its backup lock doesn't block InnoDB commits, so the mode is refused there
+	LockBackup = "backup"
+	// LockInstance uses MySQL 8.0 LOCK INSTANCE FOR BACKUP, it blocks DDL only,
+	// so snapshots and binlog coordinates match only when there are no concurrent writes
+	LockInstance = "instance"
+	// LockNone takes no lock at all
+	LockNone = "none"
+)
+
+type lockStatements struct {
+	lock   []string
+	unlock []string
+}
+
+var lockModes = map[string]lockStatements{
+	LockFTWRL: {
+		lock:   []string{"FLUSH TABLES WITH READ LOCK"},
+		unlock: []string{"UNLOCK TABLES"},
+	},
+	LockBackup: {
+		lock:   []string{"LOCK TABLES FOR BACKUP", "LOCK BINLOG FOR BACKUP"},
+		unlock: []string{"UNLOCK BINLOG", "UNLOCK TABLES"},
+	},
+	LockInstance: {
+		lock:   []string{"LOCK INSTANCE FOR BACKUP"},
+		unlock: []string{"UNLOCK INSTANCE"},
+	},
+	LockNone: {},
+}
+
+// chooseLockMode resolves LockAuto: backup locks when the server of the version has them and they block commits,
+// FLUSH TABLES WITH READ LOCK otherwise
+func chooseLockMode(mode string, hasBackupLock bool, version string) string {
+	if mode != LockAuto {
+		return mode
+	}
+	if hasBackupLock && !isVersion80(version) {
+		return LockBackup
+	}
+	return LockFTWRL
+}
+
+// isVersion80 tells if the server version is MySQL or Percona Server 8.0 or later
+func isVersion80(version string) bool {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return false
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	return err == nil && major >= 8
+}
+
+// IsLockMode tells if the mode is one of the supported lock modes
+func IsLockMode(mode string) bool {
+	_, ok := lockModes[mode]
+	return ok || mode == LockAuto
+}
+
+// lockStatementsFor returns statements of the lock mode on the server of the version
+func lockStatementsFor(mode, version string) (lockStatements, error) {
+	statements, ok := lockModes[mode]
+	if !ok {
+		return lockStatements{}, fmt.Errorf("unknown lock mode %q", mode)
+	}
+	if mode == LockBackup && isVersion80(version) {
+		// without LOCK BINLOG FOR BACKUP, snapshots and binlog coordinates would not match each other
+		return lockStatements{}, fmt.Errorf("lock mode %q does not block InnoDB commits on 8.0, use %q", LockBackup, LockFTWRL)
+	}
+	return statements, nil
+}
+
+// lock locks the server of the version using conn and returns the function releasing the lock,
+// the lock is released regardless of the context so it isn't left held when the dump is cancelled
+func lock(ctx context.Context, conn *sqlx.Conn, mode, version string) (func() error, error) {
+	statements, err := lockStatementsFor(mode, version)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case mode == LockNone:
+		log.Print("Server is not locked, snapshots and binlog position may not match each other exactly")
+	case mode == LockInstance:
+		log.Print("Instance lock does not block writes, snapshots and binlog position may not match each other exactly")
+	}
+	for _, query := range statements.lock {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("%s: %s", query, err)
+		}
+	}
+	return func() error {
+		for _, query := range statements.unlock {
+			if _, err := conn.ExecContext(context.Background(), query); err != nil {
+				return fmt.Errorf("%s: %s", query, err)
+			}
+		}
+		return nil
+	}, nil
+}
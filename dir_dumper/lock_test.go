@@ -0,0 +1,70 @@
+package dir_dumper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChooseLockMode(t *testing.T) {
+	cases := []struct {
+		mode          string
+		hasBackupLock bool
+		version       string
+		expected      string
+	}{
+		{LockAuto, true, "5.7.44-48", LockBackup},
+		{LockAuto, true, "8.0.36-28", LockFTWRL},
+		{LockAuto, false, "5.7.44", LockFTWRL},
+		{LockInstance, true, "8.0.36", LockInstance},
+		{LockBackup, false, "5.7.44", LockBackup},
+		{LockNone, true, "", LockNone},
+	}
+	for _, c := range cases {
+		if mode := chooseLockMode(c.mode, c.hasBackupLock, c.version); mode != c.expected {
+			t.Errorf("%s with backup locks %t on %q: expected %s, got %s", c.mode, c.hasBackupLock, c.version, c.expected, mode)
+		}
+	}
+}
+
+func TestLockStatements(t *testing.T) {
+	cases := []struct {
+		mode     string
+		version  string
+		expected []string
+	}{
+		{LockBackup, "5.7.44-48", []string{"LOCK TABLES FOR BACKUP", "LOCK BINLOG FOR BACKUP"}},
+		{LockBackup, "", []string{"LOCK TABLES FOR BACKUP", "LOCK BINLOG FOR BACKUP"}},
+		{LockFTWRL, "8.0.36", []string{"FLUSH TABLES WITH READ LOCK"}},
+		{LockInstance, "8.0.36", []string{"LOCK INSTANCE FOR BACKUP"}},
+	}
+	for _, c := range cases {
+		statements, err := lockStatementsFor(c.mode, c.version)
+		if err != nil {
+			t.Errorf("%s on %q: %s", c.mode, c.version, err)
+		} else if !reflect.DeepEqual(statements.lock, c.expected) {
+			t.Errorf("%s on %q: expected %v, got %v", c.mode, c.version, c.expected, statements.lock)
+		}
+	}
+	if _, err := lockStatementsFor(LockAuto, "8.0.36"); err == nil {
+		t.Error("Expected error for unresolved auto mode")
+	}
+	for _, version := range []string{"8.0.36-28", "8.4.0-1"} {
+		if _, err := lockStatementsFor(LockBackup, version); err == nil {
+			t.Errorf("Expected backup locks refused on %q", version)
+		}
+	}
+}
+
+func TestIsVersion80(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"8.0.36-28":               true,
+		"8.4.0":                   true,
+		"5.7.44-48-log":           false,
+		"10.6.16-MariaDB-0+deb11": false,
+		"":                        false,
+	} {
+		if is := isVersion80(version); is != expected {
+			t.Errorf("%q: expected %t, got %t", version, expected, is)
+		}
+	}
+}
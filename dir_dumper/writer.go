@@ -0,0 +1,54 @@
+package dir_dumper
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+// tmpSuffix is added to files being written, they get their final name only when complete
+const tmpSuffix = ".tmp"
+
+// atomicWriter writes into a temporary file which is renamed to the final name on Close,
+// so a file with the final name is always complete
+type atomicWriter struct {
+	io.WriteCloser
+	storage storage.Backend
+	name    string
+}
+
+// Close closes the temporary file and gives it the final name, it is removed when that fails
+func (w *atomicWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		w.removeTmp()
+		return err
+	}
+	if err := w.storage.Rename(w.name+tmpSuffix, w.name); err != nil {
+		w.removeTmp()
+		return err
+	}
+	return nil
+}
+
+// Abort discards and removes the temporary file, the final file is not touched
+func (w *atomicWriter) Abort() {
+	if err := storage.Abort(w.WriteCloser); err != nil {
+		log.Printf("Warning: error aborting file %q: %s", w.name+tmpSuffix, err)
+	}
+	w.removeTmp()
+}
+
+func (w *atomicWriter) removeTmp() {
+	if err := w.storage.Remove(w.name + tmpSuffix); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: error removing partially written file %q: %s", w.name+tmpSuffix, err)
+	}
+}
+
+// nopCloser is a writer which has nothing to close
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,53 @@
+package dir_dumper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+func TestAtomicWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dumper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d := NewDirDumper(storage.NewLocal(dir), nil)
+
+	w, err := d.getWriter("aborted.csjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+
+	w, err = d.getWriter("complete.csjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "complete.csjson")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file before close, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "complete.csjson" {
+		t.Errorf("Got files %v", files)
+	}
+	if b, err := ioutil.ReadFile(files[0]); err != nil || string(b) != "data" {
+		t.Errorf("Got %q, %v", b, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 var (
 	rFields = regexp.MustCompile("^\\s+`([^`]+)`")
+	rTypes  = regexp.MustCompile("^\\s+`[^`]+`\\s+([a-zA-Z]+)")
 	rTables = regexp.MustCompile("CREATE TABLE `([^`]+)`")
 )
 
@@ -32,3 +33,23 @@ func FindTableColumns(sql []byte, tableName string) []string {
 	}
 	return fields
 }
+
+// FindTableColumnTypes returns SQL type names of the table columns in the same order as FindTableColumns
+func FindTableColumnTypes(sql []byte, tableName string) []string {
+	rParser := regexp.MustCompile("CREATE TABLE `" + tableName + "`[^;]+;")
+	m := rParser.FindAllSubmatch(sql, -1)
+	types := []string{}
+	if len(m) > 0 && len(m[0]) > 0 {
+		for _, line := range strings.Split(string(m[0][0]), "\n") {
+			if rFields.MatchString(line) {
+				t := rTypes.FindStringSubmatch(line)
+				if len(t) > 1 {
+					types = append(types, t[1])
+				} else {
+					types = append(types, "")
+				}
+			}
+		}
+	}
+	return types
+}
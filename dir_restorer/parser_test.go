@@ -52,6 +52,14 @@ func TestParser(t *testing.T) {
 	t.Logf("%s", strings.Join(FindTableColumns(in, "cb_tasks"), ", "))
 	t.Logf("%s", strings.Join(FindTableColumns(in, "chart_mogul_import"), ", "))
 	t.Logf("%s", FindTableCreate(in, "cb_tasks"))
+	types := FindTableColumnTypes(in, "cb_tasks")
+	expected := []string{"int", "date", "date", "varchar", "int", "varchar", "enum", "tinyint"}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected types %v, got %v", expected, types)
+	}
+	if len(types) != len(FindTableColumns(in, "cb_tasks")) {
+		t.Errorf("Expected a type for every column")
+	}
 }
 
 //
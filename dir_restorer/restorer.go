@@ -1,52 +1,82 @@
 package dir_restorer
 
 import (
-	"compress/bzip2"
-	"compress/gzip"
-	"errors"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/url"
 	"os"
-	"os/user"
-	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/BrightLocal/MySQLBackup/compression"
+	"github.com/BrightLocal/MySQLBackup/db_info"
+	"github.com/BrightLocal/MySQLBackup/encryption"
 	"github.com/BrightLocal/MySQLBackup/filter"
+	"github.com/BrightLocal/MySQLBackup/manifest"
+	"github.com/BrightLocal/MySQLBackup/metrics"
+	"github.com/BrightLocal/MySQLBackup/progress"
+	"github.com/BrightLocal/MySQLBackup/stats"
+	"github.com/BrightLocal/MySQLBackup/storage"
 	"github.com/BrightLocal/MySQLBackup/table_restorer"
+	"github.com/BrightLocal/MySQLBackup/worker_pool"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/agent"
+	"github.com/pkg/errors"
 )
 
 type DirRestorer struct {
-	dsn           string
-	db            string
-	dir           string
-	schema        []byte
-	conn          *sqlx.DB
-	totalRows     int
-	totalBytes    int
-	totalDuration time.Duration
-	create        bool
-	truncate      bool
-	filter        filter.FilterSet
-	dryRun        bool
-}
-
-func NewDirRestorer(dir string) *DirRestorer {
+	dsn        string
+	db         string
+	storage    storage.Backend
+	schema     []byte
+	manifest   *manifest.Manifest
+	conn       *sqlx.DB
+	stats      *stats.Stats
+	progress   *progress.Tracker
+	metrics    *metrics.Metrics
+	create     bool
+	truncate   bool
+	filter     filter.FilterSet
+	dryRun     bool
+	batchRows  int
+	batchBytes int
+	method     string
+	decrypter  *encryption.Decrypter
+	// foreignKeyChecks is the global value before Prepare, to be set back by Finish
+	foreignKeyChecks *int
+}
+
+// packetOverhead is reserved in max_allowed_packet for the statement header and NULL bitmap
+const packetOverhead = 16 * 1024
+
+// NewDirRestorer returns the restorer from the storage, the storage is to be closed by the caller
+func NewDirRestorer(backend storage.Backend) *DirRestorer {
 	r := &DirRestorer{
-		dir: strings.TrimRight(dir, "/"),
+		storage:  backend,
+		stats:    stats.New(),
+		progress: progress.New(),
+		metrics:  metrics.New("tablerestorer"),
 	}
 	var err error
-	if r.schema, err = ioutil.ReadFile(dir + "/schema.sql"); err != nil {
+	if r.schema, err = r.readFile("schema.sql"); err != nil {
 		log.Fatalf("error reading schema file: %s", err)
 	}
+	if f, err := backend.Open(manifest.FileName); err == nil {
+		r.manifest, err = manifest.Read(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("error reading manifest file: %s", err)
+		}
+		if r.manifest.Interrupted {
+			log.Printf("Warning: the backup was interrupted, some tables may be missing or incomplete")
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("error opening manifest file: %s", err)
+	}
 	return r
 }
 
@@ -58,6 +88,56 @@ func (d *DirRestorer) Connect(dsn, db string) *DirRestorer {
 	if err != nil {
 		log.Fatalf("Error connecting: %s", err)
 	}
+	var maxAllowedPacket int
+	if err := d.conn.Get(&maxAllowedPacket, "SELECT @@max_allowed_packet"); err != nil {
+		log.Fatalf("Error getting max_allowed_packet: %s", err)
+	}
+	if limit := maxAllowedPacket - packetOverhead; d.batchBytes > limit {
+		log.Printf("Batch size %d bytes exceeds max_allowed_packet, using %d bytes", d.batchBytes, limit)
+		d.batchBytes = limit
+	}
+	if d.method == table_restorer.MethodLoadData {
+		var localInfile bool
+		if err := d.conn.Get(&localInfile, "SELECT @@local_infile"); err != nil {
+			log.Fatalf("Error getting local_infile: %s", err)
+		}
+		if !localInfile {
+			log.Printf("local_infile is disabled on the server, restoring with %s method", table_restorer.MethodInsert)
+			d.method = table_restorer.MethodInsert
+		}
+	}
+	return d
+}
+
+// WithMethod sets how rows are restored, must be called before Connect
+func (d *DirRestorer) WithMethod(method string) *DirRestorer {
+	d.method = method
+	return d
+}
+
+// WithBatch sets how many rows and bytes at most are inserted by a single statement, must be called before Connect
+func (d *DirRestorer) WithBatch(rows, bytes int) *DirRestorer {
+	d.batchRows = rows
+	d.batchBytes = bytes
+	return d
+}
+
+// WithDecryption sets the keys to decrypt encrypted files by, they are detected by their header.
+// The data key of a dump encrypted by a passphrase is read from its key file
+func (d *DirRestorer) WithDecryption(decrypter *encryption.Decrypter) *DirRestorer {
+	d.decrypter = decrypter
+	if decrypter == nil {
+		return d
+	}
+	key, err := d.readFile(encryption.KeyFileName)
+	if os.IsNotExist(err) {
+		return d
+	} else if err != nil {
+		log.Fatalf("error reading key file: %s", err)
+	}
+	if err := decrypter.AddKey(key); err != nil {
+		log.Fatalf("error reading key file: %s", err)
+	}
 	return d
 }
 
@@ -66,6 +146,59 @@ func (d *DirRestorer) WithFilter(filter filter.FilterSet) *DirRestorer {
 	return d
 }
 
+// WithProgress sets the tracker to report progress of restored tables to
+func (d *DirRestorer) WithProgress(tracker *progress.Tracker) *DirRestorer {
+	d.progress = tracker
+	return d
+}
+
+// WithMetrics sets the metrics to update while restoring
+func (d *DirRestorer) WithMetrics(m *metrics.Metrics) *DirRestorer {
+	d.metrics = m
+	return d
+}
+
+// Total returns totals of all restored tables
+func (d *DirRestorer) Total() stats.Table {
+	return d.stats.Total()
+}
+
+// Table is a table to restore, sized by uncompressed size of its data files in the manifest
+type Table struct {
+	Name  string
+	Bytes int64
+}
+
+func (t Table) Size() int64 {
+	return t.Bytes
+}
+
+func (t Table) String() string {
+	return t.Name
+}
+
+// Estimate sets expected files, rows and bytes of the tables from the manifest for progress,
+// and returns the tables as jobs for the worker pool
+func (d *DirRestorer) Estimate(tables []string) []worker_pool.Job {
+	jobs := make([]worker_pool.Job, 0, len(tables))
+	for _, name := range tables {
+		files := 0
+		var rows, bytes int64
+		if d.manifest != nil {
+			if table := d.manifest.Table(name); table != nil {
+				for _, file := range table.Files {
+					files++
+					rows += int64(file.Rows)
+					bytes += file.Bytes
+				}
+			}
+		}
+		d.progress.Expect(name, files, rows, bytes)
+		jobs = append(jobs, Table{Name: name, Bytes: bytes})
+	}
+	return jobs
+}
+
 func (d *DirRestorer) WithDryRun(dryRun bool) *DirRestorer {
 	d.dryRun = dryRun
 	return d
@@ -81,151 +214,256 @@ func (d *DirRestorer) TruncateTables(truncate bool) *DirRestorer {
 	return d
 }
 
+// Prepare disables foreign key checks, Finish must be called to enable them back even when restoring is interrupted
 func (d *DirRestorer) Prepare() error {
-	_, err := d.conn.Exec("SET GLOBAL FOREIGN_KEY_CHECKS = 0")
-	return err
+	var foreignKeyChecks int
+	if err := d.conn.Get(&foreignKeyChecks, "SELECT @@GLOBAL.FOREIGN_KEY_CHECKS"); err != nil {
+		return err
+	}
+	if _, err := d.conn.Exec("SET GLOBAL FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	d.foreignKeyChecks = &foreignKeyChecks
+	return nil
 }
 
+// Finish sets back server settings changed by Prepare
 func (d *DirRestorer) Finish() error {
-	_, err := d.conn.Exec("SET GLOBAL FOREIGN_KEY_CHECKS = 1")
-	return err
+	if d.foreignKeyChecks == nil {
+		return nil
+	}
+	if _, err := d.conn.Exec("SET GLOBAL FOREIGN_KEY_CHECKS = ?", *d.foreignKeyChecks); err != nil {
+		return err
+	}
+	d.foreignKeyChecks = nil
+	return nil
 }
 
-func (d *DirRestorer) getReader(fileName string) (io.ReadCloser, error) {
-	if strings.HasPrefix(d.dir, "sftp://") {
-		where, err := url.Parse(d.dir)
-		if err != nil {
-			return nil, err
-		}
-		if where.User == nil || where.User.Username() == "" {
-			// Try to figure out user name
-			if userName := os.Getenv("USER"); userName != "" {
-				where.User = url.UserPassword(userName, "")
-			} else {
-				if currentUser, err := user.Current(); err == nil {
-					where.User = url.UserPassword(currentUser.Username, "")
-				} else {
-					return nil, errors.New("user name expected")
-				}
-			}
-		}
-		if where.Path == "" {
-			return nil, errors.New("path expected")
-		}
-		if where.Host == "" {
-			return nil, errors.New("host name is empty")
+func (d *DirRestorer) readFile(fileName string) ([]byte, error) {
+	f, err := d.storage.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// Restore restores all data files of the table, it stops at the first file which fails
+func (d *DirRestorer) Restore(ctx context.Context, job worker_pool.Job) error {
+	name := job.(Table).Name
+	d.metrics.StreamStarted()
+	defer d.metrics.StreamFinished()
+	files, err := d.findFiles(name)
+	if err != nil {
+		return d.fail(name, err)
+	}
+	if !d.dryRun {
+		if err := d.prepareTable(name); err != nil {
+			return d.fail(name, err)
 		}
-		if where.Port() == "" {
-			where.Host = where.Host + ":22"
+	}
+	for _, file := range files {
+		if err := d.restoreFile(ctx, name, file); err != nil {
+			return d.fail(name, errors.Wrapf(err, "file %q", file.Name))
 		}
-		return d.getSFTPReader(fileName, where)
 	}
-	return d.getFileReader(fileName)
+	d.progress.Done(name)
+	return nil
 }
 
-func (d *DirRestorer) getSFTPReader(fileName string, where *url.URL) (io.ReadCloser, error) {
-	var authenticationMethods []ssh.AuthMethod
-	if aConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
-		authenticationMethods = append(authenticationMethods, ssh.PublicKeysCallback(agent.NewClient(aConn).Signers))
-	}
-	conn, err := ssh.Dial("tcp", where.Host, &ssh.ClientConfig{
-		User:            where.User.Username(),
-		Auth:            authenticationMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	})
+// prepareTable creates or truncates the table as requested
+func (d *DirRestorer) prepareTable(name string) error {
+	rows, err := d.conn.Query(
+		"SELECT `table_name` FROM `information_schema`.`tables` WHERE `table_schema`=? AND `table_name`=?",
+		d.db,
+		name,
+	)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "error checking if table exists")
 	}
-	f, err := sftp.NewClient(conn)
-	if err != nil {
-		return nil, err
+	exists := rows.Next()
+	rows.Close()
+	if exists {
+		if d.truncate {
+			log.Printf("Truncating table %s", name)
+			if _, err := d.conn.Exec("TRUNCATE TABLE `" + name + "`"); err != nil {
+				return errors.Wrap(err, "error clearing table")
+			}
+		}
+		return nil
+	}
+	if !d.create {
+		return errors.New("table does not exist, and automatic creation not allowed")
+	}
+	log.Printf("Creating table %s", name)
+	createQuery := FindTableCreate(d.schema, name)
+	if createQuery == "" {
+		return errors.New("could not find create statement")
+	}
+	if _, err := d.conn.Exec(createQuery); err != nil {
+		return errors.Wrap(err, "error creating table")
 	}
-	return f.Open(where.Path + "/" + fileName)
+	return nil
 }
 
-func (d *DirRestorer) getFileReader(fileName string) (io.ReadCloser, error) {
-	return os.Open(fileName)
+// findFiles returns data files of the table, from the manifest when there is one
+func (d *DirRestorer) findFiles(name string) ([]manifest.File, error) {
+	if d.manifest != nil {
+		if table := d.manifest.Table(name); table != nil {
+			if !table.Complete {
+				log.Printf("warning: table %q was not dumped completely, only rows of its dumped files are restored", name)
+			}
+			var files []manifest.File
+			for _, file := range table.Files {
+				if _, err := d.storage.Stat(file.Name); err != nil {
+					log.Printf("warning: file %q of table %q listed in manifest is missing: %s", file.Name, name, err)
+					continue
+				}
+				files = append(files, file)
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("files for table %q not found", name)
+			}
+			return files, nil
+		}
+		log.Printf("warning: table %q is not listed in manifest", name)
+	}
+	names, err := d.storage.List(storage.EscapeGlob(name) + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("error finding file for table %q: %s", name, err)
+	}
+	// either a single table file or numbered chunk files, compressed and encrypted or not
+	rFile := regexp.MustCompile("^" + regexp.QuoteMeta(name) + `(\.[0-9]+)?` + regexp.QuoteMeta(compression.DataSuffix) +
+		`(\.[a-z0-9]+)?(` + regexp.QuoteMeta(encryption.Suffix) + `)?$`)
+	var single, chunks []manifest.File
+	for _, n := range names {
+		if strings.HasSuffix(n, ".tmp") {
+			continue // incomplete file of an interrupted dump
+		}
+		m := rFile.FindStringSubmatch(n)
+		if m == nil {
+			continue
+		}
+		if m[1] == "" {
+			single = append(single, manifest.File{Name: n})
+		} else {
+			chunks = append(chunks, manifest.File{Name: n})
+		}
+	}
+	switch {
+	case len(single) == 0 && len(chunks) == 0:
+		return nil, fmt.Errorf("file for table %q not found", name)
+	case len(single) > 1 || (len(single) == 1 && len(chunks) > 0):
+		return nil, fmt.Errorf("found multiple potential files for table %q: %s", name, strings.Join(names, ", "))
+	case len(single) == 1:
+		return single, nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name < chunks[j].Name })
+	return chunks, nil
 }
 
-func (d *DirRestorer) Restore(tableName interface{}) {
-	name := tableName.(string)
-	path, err := filepath.Glob(d.dir + "/" + name + ".*")
+func (d *DirRestorer) restoreFile(ctx context.Context, name string, file manifest.File) error {
+	fileName := d.storage.Path(file.Name)
+	log.Printf("Detected file %q", fileName)
+	reader, err := d.storage.Open(file.Name)
 	if err != nil {
-		log.Printf("error finding file for table %q: %s", name, err)
-		return
+		return errors.Wrap(err, "error opening file")
 	}
-	if len(path) == 0 {
-		log.Printf("file for table %q not found", name)
-		return
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("warning: error closing file reader: %s", err)
+		}
+	}()
+	hashReader := manifest.NewHashReader(reader)
+	encrypted, data, err := encryption.Detect(hashReader)
+	if err != nil {
+		return err
 	}
-	if len(path) > 1 {
-		log.Printf("found multiple potential files for table %q: %s", name, strings.Join(path, ", "))
-		return
+	if encrypted {
+		if d.decrypter == nil {
+			return fmt.Errorf("file %q is encrypted, an identity or passphrase is needed", fileName)
+		}
+		if data, err = d.decrypter.NewReader(data); err != nil {
+			return errors.Wrap(err, "error decrypting file")
+		}
 	}
-	fileName := path[0]
-	log.Printf("Detected file %q", fileName)
-	var decompressor io.Reader
-	reader, err := d.getReader(fileName)
+	codec, buffered, err := compression.Detect(strings.TrimSuffix(fileName, encryption.Suffix), data)
 	if err != nil {
-		log.Fatalf("Error getting reader: %s", err)
+		return err
 	}
-	switch {
-	case strings.HasSuffix(fileName, ".bz2"):
-		decompressor = bzip2.NewReader(reader)
-	case strings.HasSuffix(fileName, ".gz"):
-		decompressor, _ = gzip.NewReader(reader)
+	decompressor, err := codec.NewReader(buffered)
+	if err != nil {
+		return errors.Wrapf(err, "error reading %s file", codec.Name)
 	}
-	if decompressor == nil {
-		log.Printf("could not detect compression format for file %q", fileName)
-		return
+	defer decompressor.Close()
+
+	tr := table_restorer.New(d.dsn, name, FindTableColumns(d.schema, name)).
+		WithColumnKinds(d.columnKinds(name)).
+		WithBatch(d.batchRows, d.batchBytes).
+		WithMethod(d.method).
+		WithProgress(func(rows, bytes int) {
+			d.progress.Add(name, int64(rows), int64(bytes))
+			d.metrics.Add(name, rows, bytes)
+		}).
+		WithDryRun(d.dryRun).
+		WithFilter(d.filter[name])
+	restoreResult, err := tr.Run(ctx, decompressor, d.conn)
+	d.stats.Add(stats.Table{
+		Name:     name,
+		Files:    1,
+		Rows:     restoreResult.Rows(),
+		Filtered: restoreResult.Filtered(),
+		Failed:   restoreResult.Failed(),
+		Bytes:    restoreResult.Bytes(),
+		Duration: restoreResult.Duration(),
+	})
+	d.metrics.Error(name, restoreResult.Failed())
+	if err != nil {
+		return err
 	}
+	if file.SHA256 != "" {
+		// read the rest of the file, the decompressor may stop before its end
+		if _, err := io.Copy(ioutil.Discard, hashReader); err != nil {
+			log.Printf("warning: error reading file %q: %s", fileName, err)
+		} else if sum := hashReader.Sum(); sum != file.SHA256 {
+			log.Printf("warning: checksum of file %q does not match manifest: got %s, expected %s", fileName, sum, file.SHA256)
+		}
+	}
+	d.metrics.AddFile(name, int64(restoreResult.Bytes()), hashReader.Count())
+	return nil
+}
 
-	if !d.dryRun {
-		rows, err := d.conn.Query(
-			"SELECT `table_name` FROM `information_schema`.`tables` WHERE `table_schema`=? AND `table_name`=?",
-			d.db,
-			name,
-		)
+// fail counts the error of the table and returns it
+func (d *DirRestorer) fail(name string, err error) error {
+	log.Printf("Error in table %q: %s", name, err)
+	d.stats.Fail(name, err)
+	d.metrics.Error(name, 1)
+	d.progress.Failed(name)
+	return errors.Wrapf(err, "table %q", name)
+}
 
-		if err != nil {
-			log.Fatalf("error checking if table exists: %s", err)
-		}
-		if rows.Next() {
-			if d.truncate {
-				log.Printf("Truncating table %s", name)
-				if _, err := d.conn.Exec("TRUNCATE TABLE `" + name + "`"); err != nil {
-					log.Fatalf("error clearing table %s: %s", name, err)
-				}
-			}
-		} else {
-			if d.create {
-				log.Printf("Creating table %s", name)
-				createQuery := FindTableCreate(d.schema, name)
-				if createQuery == "" {
-					log.Fatalf("could not find create statement for table %s", name)
-				}
-				if _, err := d.conn.Exec(createQuery); err != nil {
-					log.Fatalf("error creating table %s: %s", name, err)
-				}
-			} else {
-				log.Fatalf("table %s does not exist, and automatic creation not allowed", name)
+// columnKinds classifies the table columns by their types in the manifest, or in the schema when there's no manifest
+func (d *DirRestorer) columnKinds(name string) []string {
+	var types []string
+	if d.manifest != nil {
+		if table := d.manifest.Table(name); table != nil {
+			for _, column := range table.Columns {
+				types = append(types, column.Type)
 			}
 		}
 	}
-
-	tr := table_restorer.New(d.dsn, name, FindTableColumns(d.schema, name)).WithDryRun(d.dryRun).WithFilter(d.filter[name])
-	restoreResult, err := tr.Run(decompressor, d.conn)
-	if err != nil {
-		log.Printf("Error running worker: %s", err)
-		reader.Close()
-		return
+	if len(types) == 0 {
+		types = FindTableColumnTypes(d.schema, name)
 	}
-	d.totalBytes += restoreResult.Bytes()
-	d.totalRows += restoreResult.Rows()
-	d.totalDuration += restoreResult.Duration()
-	if err := reader.Close(); err != nil {
-		log.Printf("warning: error closing file reader: %s", err)
+	kinds := make([]string, len(types))
+	for i, t := range types {
+		kind, err := db_info.ColumnKind(t)
+		if err != nil {
+			log.Printf("warning: table %q column %d: %s, restoring its values as they are", name, i, err)
+		}
+		kinds[i] = kind
 	}
+	return kinds
 }
 
 func (d *DirRestorer) Tables() []string {
@@ -243,13 +481,17 @@ func (DirRestorer) findTables(sql []byte) []string {
 	return tables
 }
 
-func (d DirRestorer) PrintStats(streams int, totalDuration time.Duration) {
+func (d *DirRestorer) PrintStats(streams int, totalDuration time.Duration) {
+	total := d.stats.Total()
 	log.Printf(
 		"Restored %d rows (%d bytes) using %d streams in %s (total run time %s)",
-		d.totalRows,
-		d.totalBytes,
+		total.Rows,
+		total.Bytes,
 		streams,
-		d.totalDuration,
+		total.Duration,
 		totalDuration,
 	)
+	if err := d.stats.Print(os.Stderr); err != nil {
+		log.Printf("Error printing stats: %s", err)
+	}
 }
@@ -0,0 +1,72 @@
+package dir_restorer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/BrightLocal/MySQLBackup/storage"
+)
+
+func TestFindFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restorer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"single.csjson.bz2",
+		"chunked.00002.csjson.zst",
+		"chunked.00001.csjson.zst",
+		"chunked.00010.csjson.zst",
+		"plain.csjson",
+		"plain.csjson.tmp",
+		"encrypted.00001.csjson.zst.age",
+		"encrypted.00002.csjson.zst.age",
+		"interrupted.csjson.zst.tmp",
+		"mixed.csjson.gz",
+		"mixed.00001.csjson.gz",
+		"metadata.json",
+	} {
+		if err := ioutil.WriteFile(dir+"/"+name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &DirRestorer{storage: storage.NewLocal(dir)}
+	cases := []struct {
+		table    string
+		expected []string
+		wantErr  bool
+	}{
+		{table: "single", expected: []string{"single.csjson.bz2"}},
+		{table: "chunked", expected: []string{"chunked.00001.csjson.zst", "chunked.00002.csjson.zst", "chunked.00010.csjson.zst"}},
+		{table: "plain", expected: []string{"plain.csjson"}},
+		{table: "encrypted", expected: []string{"encrypted.00001.csjson.zst.age", "encrypted.00002.csjson.zst.age"}},
+		{table: "mixed", wantErr: true},
+		{table: "metadata", wantErr: true},
+		{table: "interrupted", wantErr: true},
+		{table: "missing", wantErr: true},
+	}
+	for _, c := range cases {
+		files, err := d.findFiles(c.table)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", c.table, files)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.table, err)
+			continue
+		}
+		if len(files) != len(c.expected) {
+			t.Errorf("%s: expected %v, got %+v", c.table, c.expected, files)
+			continue
+		}
+		for i, f := range files {
+			if f.Name != c.expected[i] {
+				t.Errorf("%s: expected %q, got %q", c.table, c.expected[i], f.Name)
+			}
+		}
+	}
+}
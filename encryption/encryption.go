@@ -0,0 +1,222 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	// Name is the encryption recorded in the manifest
+	Name = "age"
+	// Suffix is appended to names of encrypted files
+	Suffix = ".age"
+	// RecipientsEnv holds age recipients, one per line, when no recipients file is given
+	RecipientsEnv = "BACKUP_AGE_RECIPIENTS"
+	// IdentityEnv holds age identities, one per line, when no identity file is given
+	IdentityEnv = "BACKUP_AGE_IDENTITY"
+	// PassphraseEnv holds the passphrase when no passphrase file is given
+	PassphraseEnv = "BACKUP_PASSPHRASE"
+	// KeyFileName is the file of the dump holding its data key wrapped by the passphrase
+	KeyFileName = "key.age"
+)
+
+// WorkFactor is log2 of scrypt cost the data key is wrapped by the passphrase with, 18 takes about a second.
+// Scrypt runs once per dump and once per restore, data files are encrypted by the data key
+var WorkFactor = 18
+
+// magic starts every age file
+var magic = []byte("age-encryption.org/")
+
+// Encrypter encrypts files for age recipients or by a passphrase
+type Encrypter struct {
+	recipients []age.Recipient
+	passphrase string
+	key        []byte
+}
+
+// NewEncrypter returns the encrypter for recipients from the file or RecipientsEnv,
+// or for the passphrase from the file or PassphraseEnv. It's nil when no key material is given
+func NewEncrypter(recipientsFile, passphraseFile string) (*Encrypter, error) {
+	recipients, err := readKeys(recipientsFile, RecipientsEnv)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := readKeys(passphraseFile, PassphraseEnv)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case recipients != "" && passphrase != "":
+		return nil, errors.New("either recipients or a passphrase can be used, not both")
+	case recipients != "":
+		parsed, err := age.ParseRecipients(strings.NewReader(recipients))
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{recipients: parsed}, nil
+	case passphrase != "":
+		// files are encrypted for a random data key, only the key is encrypted by the passphrase
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, err
+		}
+		key, err := wrapKey(identity, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &Encrypter{recipients: []age.Recipient{identity.Recipient()}, passphrase: passphrase, key: key}, nil
+	}
+	return nil, nil
+}
+
+// Key returns the data key wrapped by the passphrase to be stored in KeyFileName, it's nil for recipients
+func (e *Encrypter) Key() []byte {
+	return e.key
+}
+
+// UseKey makes files encrypted by the data key of a previous run, so a resumed dump has a single key
+func (e *Encrypter) UseKey(key []byte) error {
+	if e.passphrase == "" {
+		return errors.New("the dump was encrypted by a passphrase")
+	}
+	identity, err := unwrapKey(key, e.passphrase)
+	if err != nil {
+		return err
+	}
+	e.recipients = []age.Recipient{identity.Recipient()}
+	e.key = key
+	return nil
+}
+
+// NewWriter encrypts what is written into w, it must be closed to write the end of the file
+func (e *Encrypter) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, e.recipients...)
+}
+
+// Decrypter decrypts files by age identities or a passphrase
+type Decrypter struct {
+	identities []age.Identity
+	passphrase string
+}
+
+// NewDecrypter returns the decrypter for identities from the file or IdentityEnv,
+// and for the passphrase from the file or PassphraseEnv. It's nil when no key material is given
+func NewDecrypter(identityFile, passphraseFile string) (*Decrypter, error) {
+	identities, err := readKeys(identityFile, IdentityEnv)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := readKeys(passphraseFile, PassphraseEnv)
+	if err != nil {
+		return nil, err
+	}
+	d := &Decrypter{}
+	if identities != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(identities))
+		if err != nil {
+			return nil, err
+		}
+		d.identities = append(d.identities, parsed...)
+	}
+	if passphrase != "" {
+		// files of dumps without a key file are encrypted by the passphrase itself
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		d.identities = append(d.identities, identity)
+		d.passphrase = passphrase
+	}
+	if len(d.identities) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// AddKey unwraps the data key of the dump from KeyFileName by the passphrase to decrypt its files by
+func (d *Decrypter) AddKey(key []byte) error {
+	if d.passphrase == "" {
+		return errors.New("the dump is encrypted by a passphrase")
+	}
+	identity, err := unwrapKey(key, d.passphrase)
+	if err != nil {
+		return err
+	}
+	// the data key goes first so scrypt is not run for every file
+	d.identities = append([]age.Identity{identity}, d.identities...)
+	return nil
+}
+
+func (d *Decrypter) NewReader(r io.Reader) (io.Reader, error) {
+	return age.Decrypt(r, d.identities...)
+}
+
+// Detect tells if the file is encrypted by its header.
+// Returned reader must be used instead of r as it has the peeked bytes buffered
+func Detect(r io.Reader) (bool, io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return false, br, err
+	}
+	return bytes.Equal(head, magic), br, nil
+}
+
+// wrapKey encrypts the data key by the passphrase with WorkFactor
+func wrapKey(identity *age.X25519Identity, passphrase string) ([]byte, error) {
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	recipient.SetWorkFactor(WorkFactor)
+	var key bytes.Buffer
+	w, err := age.Encrypt(&key, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, identity.String()+"\n"); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return key.Bytes(), nil
+}
+
+// unwrapKey decrypts the data key by the passphrase
+func unwrapKey(key []byte, passphrase string) (*age.X25519Identity, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(key), identity)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data key: %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data key: %s", err)
+	}
+	return age.ParseX25519Identity(strings.TrimSpace(string(data)))
+}
+
+// readKeys reads key material from the file, or from the environment variable when no file is given.
+// Keys are never given on the command line where other users could see them
+func readKeys(fileName, env string) (string, error) {
+	if fileName == "" {
+		return strings.TrimSpace(os.Getenv(env)), nil
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("error reading keys: %s", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,172 @@
+package encryption
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestEncryption(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(RecipientsEnv, os.Getenv(RecipientsEnv))
+	defer os.Setenv(IdentityEnv, os.Getenv(IdentityEnv))
+	defer os.Setenv(PassphraseEnv, os.Getenv(PassphraseEnv))
+	os.Unsetenv(PassphraseEnv)
+	os.Setenv(RecipientsEnv, identity.Recipient().String())
+	os.Setenv(IdentityEnv, identity.String())
+	testRoundTrip(t)
+
+	os.Unsetenv(RecipientsEnv)
+	os.Unsetenv(IdentityEnv)
+	os.Setenv(PassphraseEnv, "secret")
+	defer func(workFactor int) { WorkFactor = workFactor }(WorkFactor)
+	WorkFactor = 10
+	testRoundTrip(t)
+
+	os.Unsetenv(PassphraseEnv)
+	if e, err := NewEncrypter("", ""); e != nil || err != nil {
+		t.Errorf("Expected no encryption, got %v, %v", e, err)
+	}
+	if _, err := NewEncrypter("/missing/recipients", ""); err == nil {
+		t.Error("Expected error for missing recipients file")
+	}
+}
+
+func testRoundTrip(t *testing.T) {
+	e, err := NewEncrypter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	w, err := e.NewWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("rows")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	isEncrypted, r, err := Detect(&encrypted)
+	if err != nil || !isEncrypted {
+		t.Fatalf("Expected encrypted file, got %v, %v", isEncrypted, err)
+	}
+	d, err := NewDecrypter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := e.Key(); key != nil {
+		if err := d.AddKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r, err = d.NewReader(r); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(r); err != nil || string(data) != "rows" {
+		t.Errorf("Got %q, %v", data, err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	defer os.Setenv(PassphraseEnv, os.Getenv(PassphraseEnv))
+	defer func(workFactor int) { WorkFactor = workFactor }(WorkFactor)
+	WorkFactor = 10
+	os.Setenv(PassphraseEnv, "secret")
+	first, err := NewEncrypter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a resumed dump encrypts by the key of the first run
+	resumed, err := NewEncrypter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.UseKey(first.Key()); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecrypter("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddKey(first.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if data := roundTrip(t, resumed, d); data != "rows" {
+		t.Errorf("Got %q", data)
+	}
+
+	// files encrypted by the passphrase itself are still decrypted
+	recipient, err := age.NewScryptRecipient("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.SetWorkFactor(WorkFactor)
+	if data := roundTrip(t, &Encrypter{recipients: []age.Recipient{recipient}}, d); data != "rows" {
+		t.Errorf("Got %q", data)
+	}
+
+	os.Setenv(PassphraseEnv, "wrong")
+	if e, err := NewEncrypter("", ""); err != nil {
+		t.Fatal(err)
+	} else if err := e.UseKey(first.Key()); err == nil {
+		t.Error("Expected error unwrapping the key by a wrong passphrase")
+	}
+	if d, err := NewDecrypter("", ""); err != nil {
+		t.Fatal(err)
+	} else if err := d.AddKey(first.Key()); err == nil {
+		t.Error("Expected error unwrapping the key by a wrong passphrase")
+	}
+}
+
+// roundTrip encrypts and decrypts "rows"
+func roundTrip(t *testing.T, e *Encrypter, d *Decrypter) string {
+	var encrypted bytes.Buffer
+	w, err := e.NewWriter(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("rows")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.NewReader(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDetect(t *testing.T) {
+	for data, expected := range map[string]bool{
+		"":                                  false,
+		"BZh91AY":                           false,
+		"age-encryption.org/v1\n-> X25519 ": true,
+	} {
+		encrypted, r, err := Detect(bytes.NewBufferString(data))
+		if err != nil {
+			t.Errorf("%q: %s", data, err)
+			continue
+		}
+		if encrypted != expected {
+			t.Errorf("%q: expected %v, got %v", data, expected, encrypted)
+		}
+		if read, _ := ioutil.ReadAll(r); string(read) != data {
+			t.Errorf("Expected %q read back, got %q", data, read)
+		}
+	}
+}
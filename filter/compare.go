@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// number returns exact value of a numeric operand
+func number(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(n))
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint64:
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(n)), true
+	case float64:
+		r := new(big.Rat).SetFloat64(n)
+		return r, r != nil
+	}
+	return nil, false
+}
+
+// compare returns -1, 0 or +1 comparing value with argument,
+// strings are compared with strings and numbers with numbers of any type
+func compare(value, argument interface{}) (int, error) {
+	if v, ok := value.(string); ok {
+		if arg, ok := argument.(string); ok {
+			return strings.Compare(v, arg), nil
+		}
+		return 0, errors.Wrapf(errTypesMismatch, "%[1]v (%[1]T) != %[2]v (%[2]T)", value, argument)
+	}
+	v, ok := number(value)
+	if !ok {
+		return 0, errors.Wrapf(errTypeNotSupported, "%[1]v (%[1]T)", value)
+	}
+	arg, ok := number(argument)
+	if !ok {
+		return 0, errors.Wrapf(errTypesMismatch, "%[1]v (%[1]T) != %[2]v (%[2]T)", value, argument)
+	}
+	return v.Cmp(arg), nil
+}
+
+// equal reports whether value equals argument, numbers are equal when their values are
+func equal(value, argument interface{}) bool {
+	if v, ok := number(value); ok {
+		if arg, ok := number(argument); ok {
+			return v.Cmp(arg) == 0
+		}
+		return false
+	}
+	return value == argument
+}
@@ -1,6 +1,7 @@
 package filter
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -201,6 +202,42 @@ func TestFilter(t *testing.T) {
 			wantParseErr: true,
 			wantErr:      false,
 		},
+		{
+			name:       "BIGINT above 2^53",
+			data:       map[string]interface{}{"foo": json.Number("9007199254740993")},
+			expression: `foo > 9007199254740992`,
+			want:       true,
+		},
+		{
+			name:       "BIGINT above 2^53, ==",
+			data:       map[string]interface{}{"foo": json.Number("9007199254740993")},
+			expression: `foo == 9007199254740992`,
+			want:       false,
+		},
+		{
+			name:       "DECIMAL",
+			data:       map[string]interface{}{"foo": json.Number("12345678901234.000001")},
+			expression: `foo > 12345678901234 AND foo < 12345678901234.00001`,
+			want:       true,
+		},
+		{
+			name:       "DECIMAL with integer literal, ==",
+			data:       map[string]interface{}{"foo": json.Number("10.000")},
+			expression: `foo == 10`,
+			want:       true,
+		},
+		{
+			name:       "float with integer literal",
+			data:       map[string]interface{}{"foo": 123.5},
+			expression: `foo > 123`,
+			want:       true,
+		},
+		{
+			name:       "IN op with numbers",
+			data:       map[string]interface{}{"foo": json.Number("18446744073709551615")},
+			expression: `foo IN (1, 18446744073709551615)`,
+			want:       true,
+		},
 		{
 			name:         "types mismatch",
 			data:         map[string]interface{}{"foo": "val", "bar": 123},
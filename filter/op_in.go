@@ -17,7 +17,7 @@ func (o OpIn) Value(data map[string]interface{}) (bool, error) {
 		return false, errors.Wrapf(errFieldNotFound, "for 'IN' operation")
 	} else {
 		for _, item := range o.arguments {
-			if value == item {
+			if equal(value, item) {
 				return true, nil
 			}
 		}
@@ -16,6 +16,6 @@ func (o OpNe) Value(data map[string]interface{}) (bool, error) {
 	if value, ok := data[o.field]; !ok {
 		return false, errors.Wrapf(errFieldNotFound, "for '!=' operation")
 	} else {
-		return value != o.argument, nil
+		return !equal(value, o.argument), nil
 	}
 }
@@ -1,9 +1,10 @@
 package filter
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -193,10 +194,9 @@ func parseLiteral(in string) (interface{}, error) {
 
 	if reString.MatchString(in) {
 		result = in[1 : len(in)-1]
-	} else if parsedInt64, err := strconv.ParseInt(in, 10, 64); err == nil {
-		result = int(parsedInt64)
-	} else if parsedFloat64, err := strconv.ParseFloat(in, 64); err == nil {
-		result = parsedFloat64
+	} else if _, ok := new(big.Rat).SetString(in); ok && reNumbers.MatchString(in) {
+		// kept as text to be compared with values exactly
+		result = json.Number(in)
 	} else {
 		return nil, errors.Errorf("failed to parse literal: %v", in)
 	}
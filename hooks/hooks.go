@@ -0,0 +1,196 @@
+package hooks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Events hooks can run on
+const (
+	RunStart    = "run-start"
+	FileDone    = "file-done"
+	TableDone   = "table-done"
+	TableFailed = "table-failed"
+	RunDone     = "run-done"
+)
+
+// Statuses of a run given to run-done hooks
+const (
+	StatusOK          = "ok"
+	StatusFailed      = "failed"
+	StatusInterrupted = "interrupted"
+)
+
+// Env describes the event to the hook by HOOK_* environment variables, empty fields are not set
+type Env struct {
+	Table           string
+	File            string
+	Path            string
+	Rows            int
+	Bytes           int64
+	CompressedBytes int64
+	SHA256          string
+	Duration        time.Duration
+	Error           string
+	Status          string
+}
+
+func (e Env) environ(event string) []string {
+	env := []string{"HOOK_EVENT=" + event}
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	add("HOOK_TABLE", e.Table)
+	add("HOOK_FILE", e.File)
+	add("HOOK_FILE_PATH", e.Path)
+	if e.Rows > 0 || e.Bytes > 0 {
+		add("HOOK_ROWS", strconv.Itoa(e.Rows))
+		add("HOOK_BYTES", strconv.FormatInt(e.Bytes, 10))
+	}
+	if e.CompressedBytes > 0 {
+		add("HOOK_COMPRESSED_BYTES", strconv.FormatInt(e.CompressedBytes, 10))
+	}
+	add("HOOK_SHA256", e.SHA256)
+	if e.Duration > 0 {
+		add("HOOK_DURATION", strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64))
+	}
+	add("HOOK_ERROR", e.Error)
+	add("HOOK_STATUS", e.Status)
+	return env
+}
+
+// Hooks runs shell commands on events, up to the concurrency limit at once. A hook which runs longer
+// than the timeout is killed, it counts as failed as well as a hook which exits with non-zero status
+type Hooks struct {
+	commands map[string]string
+	env      []string
+	slots    chan struct{}
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	failed   int
+}
+
+// New returns hooks running up to concurrency commands at once, each for up to the timeout, 0 for no timeout
+func New(concurrency int, timeout time.Duration) *Hooks {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Hooks{
+		commands: make(map[string]string),
+		slots:    make(chan struct{}, concurrency),
+		timeout:  timeout,
+		timeouts: make(map[string]time.Duration),
+	}
+}
+
+// WithTimeout sets the timeout of the hook of the event instead of the one for all hooks, 0 for no timeout
+func (h *Hooks) WithTimeout(event string, timeout time.Duration) *Hooks {
+	h.timeouts[event] = timeout
+	return h
+}
+
+// On sets the command to run on the event, %FILE_NAME% and %FILE_PATH% in it are replaced for file events
+func (h *Hooks) On(event, command string) *Hooks {
+	if command != "" {
+		h.commands[event] = command
+	}
+	return h
+}
+
+// WithEnv sets the environment variable for all hooks
+func (h *Hooks) WithEnv(name, value string) *Hooks {
+	h.env = append(h.env, name+"="+value)
+	return h
+}
+
+// Start runs the hook of the event in the background, Wait waits for it
+func (h *Hooks) Start(event string, env Env) {
+	command, ok := h.commands[event]
+	if !ok {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.run(event, command, env)
+	}()
+}
+
+// Run runs the hook of the event and waits for it
+func (h *Hooks) Run(event string, env Env) error {
+	command, ok := h.commands[event]
+	if !ok {
+		return nil
+	}
+	return h.run(event, command, env)
+}
+
+// Wait waits for hooks started in the background and returns how many hooks failed
+func (h *Hooks) Wait() int {
+	h.wg.Wait()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failed
+}
+
+func (h *Hooks) run(event, command string, env Env) error {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	command = strings.Replace(command, "%FILE_NAME%", env.File, -1)
+	command = strings.Replace(command, "%FILE_PATH%", env.Path, -1)
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(append(os.Environ(), h.env...), env.environ(event)...)
+	// output goes right to stderr, so Wait doesn't wait for children which keep it open
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// the hook gets its own process group, so the timeout kills commands started by the shell too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	started := time.Now()
+	timeout, ok := h.timeouts[event]
+	if !ok {
+		timeout = h.timeout
+	}
+	err := cmd.Start()
+	if err == nil {
+		err = wait(cmd, timeout)
+	}
+	if err != nil {
+		h.mu.Lock()
+		h.failed++
+		h.mu.Unlock()
+		log.Printf("Error in %s hook %q: %s", event, command, err)
+		return err
+	}
+	log.Printf("Finished %s hook %q in %s", event, command, time.Now().Sub(started))
+	return nil
+}
+
+// wait waits for the command, it kills the process group of the command when the timeout passes
+func wait(cmd *exec.Cmd, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	if timeout <= 0 {
+		return <-done
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return fmt.Errorf("killed after %s", timeout)
+	}
+}
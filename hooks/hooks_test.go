@@ -0,0 +1,76 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	h := New(2, time.Minute).
+		WithEnv("HOOK_DATABASE", "shop").
+		On(FileDone, `echo "%FILE_NAME% $HOOK_EVENT $HOOK_DATABASE $HOOK_TABLE $HOOK_ROWS $HOOK_BYTES $HOOK_SHA256 $HOOK_DURATION" > `+out)
+	if err := h.Run(FileDone, Env{
+		Table:    "users",
+		File:     "users.tsv.bz2",
+		Rows:     10,
+		Bytes:    100,
+		SHA256:   "abc",
+		Duration: 1500 * time.Millisecond,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "users.tsv.bz2 file-done shop users 10 100 abc 1.500\n"; string(b) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(b))
+	}
+	if err := h.Run(RunStart, Env{}); err != nil {
+		t.Errorf("Expected no error without a command, got %s", err)
+	}
+	if failed := h.Wait(); failed != 0 {
+		t.Errorf("Expected no failures, got %d", failed)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	h := New(1, 100*time.Millisecond).
+		On(TableDone, `test "$HOOK_TABLE" = users`).
+		On(TableFailed, "sleep 5")
+	h.Start(TableDone, Env{Table: "users"})
+	h.Start(TableDone, Env{Table: "orders"})
+	started := time.Now()
+	if err := h.Run(TableFailed, Env{Table: "log"}); err == nil || !strings.Contains(err.Error(), "killed") {
+		t.Errorf("Expected the hook killed, got %v", err)
+	}
+	if failed := h.Wait(); failed != 2 {
+		t.Errorf("Expected 2 failures, got %d", failed)
+	}
+	if elapsed := time.Now().Sub(started); elapsed > 2*time.Second {
+		t.Errorf("Expected the hook killed by timeout, took %s", elapsed)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	h := New(2, 100*time.Millisecond).
+		WithTimeout(FileDone, 0).
+		On(FileDone, "sleep 0.3").
+		On(TableDone, "sleep 0.3")
+	if err := h.Run(FileDone, Env{}); err != nil {
+		t.Errorf("Expected the hook without timeout to finish, got %s", err)
+	}
+	if err := h.Run(TableDone, Env{}); err == nil {
+		t.Error("Expected the hook killed by the timeout of all hooks")
+	}
+}
@@ -0,0 +1,56 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// HashWriter counts and hashes everything written through it
+type HashWriter struct {
+	w     io.Writer
+	h     hash.Hash
+	count int64
+}
+
+func NewHashWriter(w io.Writer) *HashWriter {
+	return &HashWriter{
+		w: w,
+		h: sha256.New(),
+	}
+}
+
+func (w *HashWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.h.Write(p[:n])
+	w.count += int64(n)
+	return n, err
+}
+
+func (w *HashWriter) Count() int64 { return w.count }
+func (w *HashWriter) Sum() string  { return hex.EncodeToString(w.h.Sum(nil)) }
+
+// HashReader counts and hashes everything read through it
+type HashReader struct {
+	r     io.Reader
+	h     hash.Hash
+	count int64
+}
+
+func NewHashReader(r io.Reader) *HashReader {
+	return &HashReader{
+		r: r,
+		h: sha256.New(),
+	}
+}
+
+func (r *HashReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.count += int64(n)
+	return n, err
+}
+
+func (r *HashReader) Count() int64 { return r.count }
+func (r *HashReader) Sum() string  { return hex.EncodeToString(r.h.Sum(nil)) }
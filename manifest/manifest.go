@@ -0,0 +1,173 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	FileName      = "manifest.json"
+	FormatVersion = 1
+)
+
+// Manifest describes a backup: which files belong to which table and how to verify them
+type Manifest struct {
+	FormatVersion int         `json:"format_version"`
+	ServerVersion string      `json:"server_version"`
+	Started       time.Time   `json:"started"`
+	Finished      time.Time   `json:"finished"`
+	Resumed       []time.Time `json:"resumed,omitempty"`
+	Interrupted   bool        `json:"interrupted,omitempty"`
+	Tables        []*Table    `json:"tables"`
+	Failed        []string    `json:"failed,omitempty"` // tables which failed to dump, they may have no files at all
+	mu            sync.Mutex
+}
+
+type Table struct {
+	Name     string   `json:"name"`
+	Columns  []Column `json:"columns"`
+	Files    []File   `json:"files"`
+	Complete bool     `json:"complete"`
+}
+
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type File struct {
+	Name            string `json:"name"`
+	Compression     string `json:"compression"`
+	Encryption      string `json:"encryption,omitempty"`
+	Rows            int    `json:"rows"`
+	Bytes           int64  `json:"bytes"`
+	CompressedBytes int64  `json:"compressed_bytes"`
+	SHA256          string `json:"sha256"`
+}
+
+func New(serverVersion string, started time.Time) *Manifest {
+	return &Manifest{
+		FormatVersion: FormatVersion,
+		ServerVersion: serverVersion,
+		Started:       started,
+	}
+}
+
+// Read decodes a manifest
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddFile adds a dumped file of the table, safe for concurrent use
+func (m *Manifest) AddFile(tableName string, columns []Column, file File) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.Tables {
+		if t.Name == tableName {
+			t.Files = append(t.Files, file)
+			return
+		}
+	}
+	m.Tables = append(m.Tables, &Table{
+		Name:    tableName,
+		Columns: columns,
+		Files:   []File{file},
+	})
+}
+
+// Complete marks the table as dumped completely, safe for concurrent use
+func (m *Manifest) Complete(tableName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.Tables {
+		if t.Name == tableName {
+			t.Complete = true
+		}
+	}
+}
+
+// Fail records the table failed to dump, safe for concurrent use
+func (m *Manifest) Fail(tableName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range m.Failed {
+		if name == tableName {
+			return
+		}
+	}
+	m.Failed = append(m.Failed, tableName)
+}
+
+// DropIncomplete removes tables which were not dumped completely and forgets failed ones, so they can be dumped again
+func (m *Manifest) DropIncomplete() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Failed = nil
+	tables := m.Tables[:0]
+	for _, t := range m.Tables {
+		if t.Complete {
+			tables = append(tables, t)
+		}
+	}
+	m.Tables = tables
+}
+
+// Table returns the table by name or nil if the manifest does not have it
+func (m *Manifest) Table(name string) *Table {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.Tables {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Files returns a copy of files of the table, safe for concurrent use
+func (m *Manifest) Files(tableName string) []File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.Tables {
+		if t.Name == tableName {
+			return append([]File(nil), t.Files...)
+		}
+	}
+	return nil
+}
+
+// Write encodes the manifest with tables and files sorted by name, the writer is written without holding the lock
+func (m *Manifest) Write(w io.Writer) error {
+	data, err := m.encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (m *Manifest) encode() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.Slice(m.Tables, func(i, j int) bool { return m.Tables[i].Name < m.Tables[j].Name })
+	sort.Strings(m.Failed)
+	for _, t := range m.Tables {
+		files := t.Files
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
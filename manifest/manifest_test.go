@@ -0,0 +1,123 @@
+package manifest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestReadWrite(t *testing.T) {
+	m := New("5.7.25-log", time.Date(2019, 3, 1, 2, 0, 0, 0, time.UTC))
+	m.AddFile("zeta", nil, File{Name: "zeta.csjson.bz2", Rows: 2})
+	columns := []Column{{Name: "id", Type: "int(10) unsigned"}}
+	m.AddFile("alpha", columns, File{Name: "alpha.00002.csjson.bz2", Rows: 5})
+	m.AddFile("alpha", columns, File{Name: "alpha.00001.csjson.bz2", Rows: 10, Bytes: 100, CompressedBytes: 50, SHA256: "abc"})
+	b := &bytes.Buffer{}
+	if err := m.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FormatVersion != FormatVersion || r.ServerVersion != "5.7.25-log" {
+		t.Errorf("Got %+v", r)
+	}
+	if len(r.Tables) != 2 || r.Tables[0].Name != "alpha" || r.Tables[1].Name != "zeta" {
+		t.Fatalf("Expected tables sorted by name, got %+v", r.Tables)
+	}
+	alpha := r.Table("alpha")
+	if alpha == nil || alpha.Columns[0].Type != "int(10) unsigned" || len(alpha.Files) != 2 || alpha.Files[0].CompressedBytes != 50 {
+		t.Errorf("Got %+v", alpha)
+	}
+	if r.Table("missing") != nil {
+		t.Error("Expected nil for missing table")
+	}
+}
+
+func TestDropIncomplete(t *testing.T) {
+	m := New("", time.Now())
+	m.AddFile("done", nil, File{Name: "done.csjson.bz2"})
+	m.AddFile("partial", nil, File{Name: "partial.00001.csjson.bz2"})
+	m.Complete("done")
+	b := &bytes.Buffer{}
+	if err := m.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.DropIncomplete()
+	if len(r.Tables) != 1 || r.Table("done") == nil || !r.Table("done").Complete {
+		t.Errorf("Got %+v", r.Tables)
+	}
+}
+
+func TestFail(t *testing.T) {
+	m := New("", time.Now())
+	m.Fail("orders")
+	m.Fail("orders")
+	b := &bytes.Buffer{}
+	if err := m.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Failed) != 1 || r.Failed[0] != "orders" {
+		t.Errorf("Got %v", r.Failed)
+	}
+	// a resumed dump dumps failed tables again
+	r.DropIncomplete()
+	if len(r.Failed) != 0 {
+		t.Errorf("Expected failed tables forgotten, got %v", r.Failed)
+	}
+}
+
+// blockingWriter adds a file to the manifest while it's being written
+type blockingWriter struct {
+	m *Manifest
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	w.m.AddFile("other", nil, File{Name: "other.csjson"})
+	return len(p), nil
+}
+
+func TestWriteUnlocked(t *testing.T) {
+	m := New("", time.Now())
+	m.AddFile("users", nil, File{Name: "users.csjson"})
+	done := make(chan error)
+	go func() { done <- m.Write(blockingWriter{m}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the manifest unlocked while it's written")
+	}
+}
+
+func TestHashWriterReader(t *testing.T) {
+	const expected = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" // sha256("hello world")
+	b := &bytes.Buffer{}
+	w := NewHashWriter(b)
+	if _, err := io.WriteString(w, "hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Count() != 11 || w.Sum() != expected {
+		t.Errorf("Got %d %s", w.Count(), w.Sum())
+	}
+	r := NewHashReader(b)
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Count() != 11 || r.Sum() != expected {
+		t.Errorf("Got %d %s", r.Count(), r.Sum())
+	}
+}
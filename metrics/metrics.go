@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics of a single run of a tool, every metric name is prefixed with the tool name
+type Metrics struct {
+	tool             string
+	registry         *prometheus.Registry
+	rows             *prometheus.CounterVec
+	bytes            *prometheus.CounterVec
+	compressedBytes  *prometheus.CounterVec
+	compressionRatio prometheus.Gauge
+	activeStreams    prometheus.Gauge
+	errors           *prometheus.CounterVec
+	lockHeld         prometheus.Gauge
+	mu               sync.Mutex
+	totalBytes       int64
+	totalCompressed  int64
+}
+
+func New(tool string) *Metrics {
+	m := &Metrics{
+		tool:     tool,
+		registry: prometheus.NewRegistry(),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: tool,
+			Name:      "rows_total",
+			Help:      "Rows processed per table.",
+		}, []string{"table"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: tool,
+			Name:      "bytes_total",
+			Help:      "Uncompressed bytes processed per table.",
+		}, []string{"table"}),
+		compressedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: tool,
+			Name:      "compressed_bytes_total",
+			Help:      "Compressed bytes of data files per table.",
+		}, []string{"table"}),
+		compressionRatio: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: tool,
+			Name:      "compression_ratio",
+			Help:      "Uncompressed to compressed size ratio of data files processed so far.",
+		}),
+		activeStreams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: tool,
+			Name:      "active_streams",
+			Help:      "Streams processing a data file.",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: tool,
+			Name:      "errors_total",
+			Help:      "Failed data files and rows per table.",
+		}, []string{"table"}),
+		lockHeld: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: tool,
+			Name:      "lock_held_seconds",
+			Help:      "How long the server was locked while snapshots were started.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.rows,
+		m.bytes,
+		m.compressedBytes,
+		m.compressionRatio,
+		m.activeStreams,
+		m.errors,
+		m.lockHeld,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Serve serves the metrics on /metrics at the address in background
+func (m *Metrics) Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Error serving metrics: %s", err)
+		}
+	}()
+}
+
+// Add adds rows and uncompressed bytes processed of the table
+func (m *Metrics) Add(table string, rows, bytes int) {
+	m.rows.WithLabelValues(table).Add(float64(rows))
+	m.bytes.WithLabelValues(table).Add(float64(bytes))
+}
+
+// AddFile adds uncompressed and compressed size of a data file of the table
+func (m *Metrics) AddFile(table string, bytes, compressed int64) {
+	m.compressedBytes.WithLabelValues(table).Add(float64(compressed))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.totalBytes += bytes
+	m.totalCompressed += compressed
+	if m.totalCompressed > 0 {
+		m.compressionRatio.Set(float64(m.totalBytes) / float64(m.totalCompressed))
+	}
+}
+
+func (m *Metrics) StreamStarted() {
+	m.activeStreams.Inc()
+}
+
+func (m *Metrics) StreamFinished() {
+	m.activeStreams.Dec()
+}
+
+// Error adds failed data files or rows of the table
+func (m *Metrics) Error(table string, n int) {
+	m.errors.WithLabelValues(table).Add(float64(n))
+}
+
+func (m *Metrics) LockHeld(d time.Duration) {
+	m.lockHeld.Set(d.Seconds())
+}
+
+// WriteTextfile writes the file for node_exporter textfile collector after a successful run
+func (m *Metrics) WriteTextfile(path string, duration time.Duration, rows, bytes int) error {
+	registry := prometheus.NewRegistry()
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"last_success_timestamp_seconds", "When the last successful run finished.", float64(time.Now().Unix())},
+		{"last_success_duration_seconds", "How long the last successful run took.", duration.Seconds()},
+		{"last_success_rows", "Rows processed by the last successful run.", float64(rows)},
+		{"last_success_bytes", "Uncompressed bytes processed by the last successful run.", float64(bytes)},
+	}
+	for _, g := range gauges {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: m.tool, Name: g.name, Help: g.help})
+		gauge.Set(g.value)
+		registry.MustRegister(gauge)
+	}
+	return prometheus.WriteToTextfile(path, registry)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("tabledumper")
+	m.Add("users", 10, 1000)
+	m.Add("users", 5, 500)
+	m.AddFile("users", 1500, 300)
+	m.AddFile("orders", 500, 200)
+	m.Error("orders", 1)
+	m.StreamStarted()
+	m.StreamStarted()
+	m.StreamFinished()
+	if v := testutil.ToFloat64(m.rows.WithLabelValues("users")); v != 15 {
+		t.Errorf("Got %v rows", v)
+	}
+	if v := testutil.ToFloat64(m.compressionRatio); v != 4 {
+		t.Errorf("Got %v compression ratio", v)
+	}
+	if v := testutil.ToFloat64(m.activeStreams); v != 1 {
+		t.Errorf("Got %v active streams", v)
+	}
+	if v := testutil.ToFloat64(m.errors.WithLabelValues("orders")); v != 1 {
+		t.Errorf("Got %v errors", v)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tabledumper.prom")
+	if err := New("tabledumper").WriteTextfile(path, 90*time.Second, 15, 1500); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"tabledumper_last_success_timestamp_seconds ",
+		"tabledumper_last_success_duration_seconds 90\n",
+		"tabledumper_last_success_rows 15\n",
+		"tabledumper_last_success_bytes 1500\n",
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("Expected %q in\n%s", expected, b)
+		}
+	}
+}
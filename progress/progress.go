@@ -0,0 +1,294 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportRows is how often in rows dumpers and restorers report their progress
+const ReportRows = 10000
+
+type table struct {
+	name      string
+	files     int
+	filesDone int
+	estRows   int64
+	estBytes  int64
+	rows      int64
+	bytes     int64
+	done      bool
+	failed    bool
+	changed   bool
+}
+
+// percent of the table done, estimated by rows, or by files when there's no row estimate
+func (t *table) percent() float64 {
+	switch {
+	case t.done:
+		return 100
+	case t.estRows > 0:
+		return capPercent(float64(t.rows) / float64(t.estRows) * 100)
+	case t.files > 0:
+		return capPercent(float64(t.filesDone) / float64(t.files) * 100)
+	}
+	return 0
+}
+
+// capPercent keeps the percent below 100 until the table is done, as estimates may be too low
+func capPercent(p float64) float64 {
+	if p > 99.9 {
+		return 99.9
+	}
+	return p
+}
+
+// Tracker keeps progress of tables against their estimates, safe to use from several streams
+type Tracker struct {
+	mu      sync.Mutex
+	tables  map[string]*table
+	started time.Time
+	json    io.Writer
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func New() *Tracker {
+	return &Tracker{
+		tables:  make(map[string]*table),
+		started: time.Now(),
+	}
+}
+
+// WithJSON sets where to write progress as JSON lines
+func (t *Tracker) WithJSON(w io.Writer) *Tracker {
+	t.json = w
+	return t
+}
+
+func (t *Tracker) table(name string) *table {
+	tbl, ok := t.tables[name]
+	if !ok {
+		tbl = &table{name: name}
+		t.tables[name] = tbl
+	}
+	return tbl
+}
+
+// Expect sets how many files, rows and bytes the table is expected to have
+func (t *Tracker) Expect(name string, files int, rows, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tbl := t.table(name)
+	tbl.files, tbl.estRows, tbl.estBytes = files, rows, bytes
+}
+
+// Add adds rows and bytes done of the table
+func (t *Tracker) Add(name string, rows, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tbl := t.table(name)
+	tbl.rows += rows
+	tbl.bytes += bytes
+	tbl.changed = true
+}
+
+// FileDone marks a file of the table done, the table is done with its last file
+func (t *Tracker) FileDone(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tbl := t.table(name)
+	tbl.filesDone++
+	if tbl.filesDone >= tbl.files {
+		tbl.done = true
+	}
+	tbl.changed = true
+}
+
+// Failed marks the table failed, it is never done then
+func (t *Tracker) Failed(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tbl := t.table(name)
+	tbl.failed = true
+	tbl.changed = true
+}
+
+// Done marks the table done
+func (t *Tracker) Done(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tbl := t.table(name)
+	tbl.done = true
+	tbl.changed = true
+}
+
+// Start reports progress into the log and as JSON lines every interval
+func (t *Tracker) Start(interval time.Duration) {
+	t.mu.Lock()
+	t.started = time.Now()
+	t.mu.Unlock()
+	if interval <= 0 {
+		return
+	}
+	t.stop = make(chan struct{})
+	t.stopped = make(chan struct{})
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.report(true)
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting and writes the final report
+func (t *Tracker) Stop() {
+	if t.stop != nil {
+		close(t.stop)
+		<-t.stopped
+	}
+	t.report(false)
+}
+
+// TableReport is progress of a single table
+type TableReport struct {
+	Name           string  `json:"name"`
+	Percent        float64 `json:"percent"`
+	Rows           int64   `json:"rows"`
+	Bytes          int64   `json:"bytes"`
+	EstimatedRows  int64   `json:"estimated_rows"`
+	EstimatedBytes int64   `json:"estimated_bytes"`
+	Done           bool    `json:"done"`
+	Failed         bool    `json:"failed,omitempty"`
+}
+
+// Report is overall progress, with tables changed since the previous report
+type Report struct {
+	Time           time.Time     `json:"time"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	Percent        float64       `json:"percent"`
+	TablesDone     int           `json:"tables_done"`
+	TablesFailed   int           `json:"tables_failed"`
+	Tables         int           `json:"tables"`
+	Rows           int64         `json:"rows"`
+	Bytes          int64         `json:"bytes"`
+	RowsPerSecond  float64       `json:"rows_per_second"`
+	BytesPerSecond float64       `json:"bytes_per_second"`
+	ETASeconds     float64       `json:"eta_seconds"`
+	Changed        []TableReport `json:"changed_tables"`
+}
+
+// Report returns current progress, tables changed since the previous report are reset as reported
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	r := Report{
+		Time:           now,
+		ElapsedSeconds: now.Sub(t.started).Seconds(),
+		Tables:         len(t.tables),
+		Changed:        []TableReport{},
+	}
+	var estRows, doneRows float64
+	for _, tbl := range t.tables {
+		r.Rows += tbl.rows
+		r.Bytes += tbl.bytes
+		if tbl.done {
+			r.TablesDone++
+		}
+		if tbl.failed {
+			r.TablesFailed++
+		}
+		// tables without row estimate count as a single row
+		weight := float64(tbl.estRows)
+		if weight == 0 {
+			weight = 1
+		}
+		estRows += weight
+		doneRows += weight * tbl.percent() / 100
+		if tbl.changed {
+			tbl.changed = false
+			r.Changed = append(r.Changed, TableReport{
+				Name:           tbl.name,
+				Percent:        tbl.percent(),
+				Rows:           tbl.rows,
+				Bytes:          tbl.bytes,
+				EstimatedRows:  tbl.estRows,
+				EstimatedBytes: tbl.estBytes,
+				Done:           tbl.done,
+				Failed:         tbl.failed,
+			})
+		}
+	}
+	sort.Slice(r.Changed, func(i, j int) bool { return r.Changed[i].Name < r.Changed[j].Name })
+	if estRows > 0 {
+		r.Percent = doneRows / estRows * 100
+	}
+	if r.ElapsedSeconds > 0 {
+		r.RowsPerSecond = float64(r.Rows) / r.ElapsedSeconds
+		r.BytesPerSecond = float64(r.Bytes) / r.ElapsedSeconds
+	}
+	if r.Percent > 0 {
+		r.ETASeconds = r.ElapsedSeconds * (100 - r.Percent) / r.Percent
+	}
+	return r
+}
+
+func (t *Tracker) report(logging bool) {
+	r := t.Report()
+	if logging {
+		log.Print(r.String())
+	}
+	if t.json != nil {
+		b, err := json.Marshal(r)
+		if err != nil {
+			log.Printf("Error encoding progress: %s", err)
+			return
+		}
+		if _, err := t.json.Write(append(b, '\n')); err != nil {
+			log.Printf("Error writing progress: %s", err)
+		}
+	}
+}
+
+// String formats the report as a log line with tables in progress
+func (r Report) String() string {
+	line := fmt.Sprintf(
+		"Progress: %.1f%%, %d of %d tables, %d rows (%.0f rows/s), %.1f MB (%.1f MB/s), ETA %s",
+		r.Percent,
+		r.TablesDone,
+		r.Tables,
+		r.Rows,
+		r.RowsPerSecond,
+		float64(r.Bytes)/1e6,
+		r.BytesPerSecond/1e6,
+		(time.Duration(r.ETASeconds) * time.Second).String(),
+	)
+	if r.TablesFailed > 0 {
+		line += fmt.Sprintf(", %d tables failed", r.TablesFailed)
+	}
+	var active []string
+	for _, tbl := range r.Changed {
+		if tbl.Failed {
+			active = append(active, tbl.Name+" failed")
+		} else if !tbl.Done {
+			active = append(active, fmt.Sprintf("%s %.1f%%", tbl.Name, tbl.Percent))
+		}
+	}
+	if len(active) > 0 {
+		line += " [" + strings.Join(active, ", ") + "]"
+	}
+	return line
+}
@@ -0,0 +1,77 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	tr := New()
+	tr.Expect("a", 2, 1000, 0)
+	tr.Expect("b", 1, 3000, 0)
+	tr.Add("a", 500, 100)
+	tr.Add("b", 3500, 700) // more rows than estimated
+	r := tr.Report()
+	if r.Rows != 4000 || r.Bytes != 800 || r.TablesDone != 0 || r.Tables != 2 {
+		t.Errorf("Got %+v", r)
+	}
+	if len(r.Changed) != 2 || r.Changed[0].Name != "a" || r.Changed[0].Percent != 50 || r.Changed[1].Percent != 99.9 {
+		t.Errorf("Got %+v", r.Changed)
+	}
+	if r := tr.Report(); len(r.Changed) != 0 {
+		t.Errorf("Expected no changes, got %+v", r.Changed)
+	}
+
+	tr.FileDone("b")
+	tr.FileDone("a")
+	if r := tr.Report(); r.TablesDone != 1 || r.Percent != 87.5 {
+		t.Errorf("Got %+v", r)
+	}
+	tr.FileDone("a")
+	if r := tr.Report(); r.TablesDone != 2 || r.Percent != 100 || r.ETASeconds != 0 {
+		t.Errorf("Got %+v", r)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	tr := New().WithJSON(b)
+	tr.Expect("a", 0, 0, 0)
+	tr.Start(0)
+	tr.Done("a")
+	tr.Stop()
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Got %q", b.String())
+	}
+	var r Report
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Percent != 100 || len(r.Changed) != 1 || !r.Changed[0].Done {
+		t.Errorf("Got %+v", r)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	tr := New()
+	tr.Expect("a", 2, 1000, 0)
+	tr.Expect("b", 1, 1000, 0)
+	tr.Add("a", 500, 100)
+	tr.FileDone("a")
+	tr.Failed("a")
+	tr.Add("b", 1000, 100)
+	tr.FileDone("b")
+	r := tr.Report()
+	if r.TablesDone != 1 || r.TablesFailed != 1 || r.Percent != 75 {
+		t.Errorf("Got %+v", r)
+	}
+	if len(r.Changed) != 2 || r.Changed[0].Done || !r.Changed[0].Failed || r.Changed[0].Percent != 50 {
+		t.Errorf("Got %+v", r.Changed)
+	}
+	if line := r.String(); !strings.Contains(line, "1 tables failed") || !strings.Contains(line, "[a failed]") {
+		t.Errorf("Got %q", line)
+	}
+}
@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Table holds totals of a single table
+type Table struct {
+	Name     string
+	Files    int
+	Rows     int
+	Filtered int
+	Failed   int
+	Bytes    int
+	Duration time.Duration
+	Errors   int
+}
+
+func (t *Table) add(o Table) {
+	t.Files += o.Files
+	t.Rows += o.Rows
+	t.Filtered += o.Filtered
+	t.Failed += o.Failed
+	t.Bytes += o.Bytes
+	t.Duration += o.Duration
+	t.Errors += o.Errors
+}
+
+// Failure is an error which failed a table or one of its files
+type Failure struct {
+	Table string
+	Err   error
+}
+
+// Stats aggregates totals of tables, safe to use from several streams
+type Stats struct {
+	mu       sync.Mutex
+	tables   map[string]*Table
+	failures []Failure
+}
+
+func New() *Stats {
+	return &Stats{tables: make(map[string]*Table)}
+}
+
+// Add adds the totals to the table with the same name
+func (s *Stats) Add(t Table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	table, ok := s.tables[t.Name]
+	if !ok {
+		table = &Table{Name: t.Name}
+		s.tables[t.Name] = table
+	}
+	table.add(t)
+}
+
+// Fail counts an error of the table and remembers it for the report
+func (s *Stats) Fail(name string, err error) {
+	s.Add(Table{Name: name, Errors: 1})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, Failure{Table: name, Err: err})
+}
+
+// Failures returns errors of tables in order they happened
+func (s *Stats) Failures() []Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Failure(nil), s.failures...)
+}
+
+// Tables returns totals of every table sorted by name
+func (s *Stats) Tables() []Table {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tables := make([]Table, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, *t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// Table returns totals of the table
+func (s *Stats) Table(name string) Table {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tables[name]; ok {
+		return *t
+	}
+	return Table{Name: name}
+}
+
+// Total returns totals of all tables
+func (s *Stats) Total() Table {
+	total := Table{Name: "TOTAL"}
+	for _, t := range s.Tables() {
+		total.add(t)
+	}
+	return total
+}
+
+// Print writes the summary table, columns without any value such as filtered rows are omitted
+func (s *Stats) Print(w io.Writer) error {
+	total := s.Total()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	row := func(name, files, rows, filtered, failed, bytes, duration, errors string) {
+		cells := []string{name, files, rows}
+		if total.Filtered > 0 {
+			cells = append(cells, filtered)
+		}
+		if total.Failed > 0 {
+			cells = append(cells, failed)
+		}
+		cells = append(cells, bytes, duration)
+		if total.Errors > 0 {
+			cells = append(cells, errors)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	row("TABLE", "FILES", "ROWS", "FILTERED", "FAILED", "BYTES", "TIME", "ERRORS")
+	for _, t := range append(s.Tables(), total) {
+		row(
+			t.Name,
+			fmt.Sprint(t.Files),
+			fmt.Sprint(t.Rows),
+			fmt.Sprint(t.Filtered),
+			fmt.Sprint(t.Failed),
+			fmt.Sprint(t.Bytes),
+			t.Duration.Round(time.Millisecond).String(),
+			fmt.Sprint(t.Errors),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	failures := s.Failures()
+	if len(failures) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "%d errors:\n", len(failures)); err != nil {
+		return err
+	}
+	for _, f := range failures {
+		if _, err := fmt.Fprintf(w, "  %s: %s\n", f.Table, f.Err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
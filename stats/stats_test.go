@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Add(Table{Name: "b", Files: 1, Rows: 10, Bytes: 100, Duration: time.Second})
+			s.Add(Table{Name: "a", Files: 1, Rows: 1, Filtered: 2})
+		}()
+	}
+	wg.Wait()
+	tables := s.Tables()
+	if len(tables) != 2 || tables[0].Name != "a" || tables[1].Name != "b" {
+		t.Fatalf("Got %+v", tables)
+	}
+	if tables[1].Rows != 1000 || tables[1].Files != 100 || tables[1].Duration != 100*time.Second {
+		t.Errorf("Got %+v", tables[1])
+	}
+	if total := s.Total(); total.Rows != 1100 || total.Filtered != 200 || total.Bytes != 10000 {
+		t.Errorf("Got %+v", total)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	s := New()
+	s.Add(Table{Name: "users", Files: 2, Rows: 15, Bytes: 300, Duration: 1500 * time.Millisecond})
+	s.Add(Table{Name: "log", Files: 1, Rows: 5, Failed: 1, Bytes: 50, Duration: 500 * time.Millisecond})
+	b := &bytes.Buffer{}
+	if err := s.Print(b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"TABLE  FILES  ROWS  FAILED  BYTES  TIME\n" +
+		"log    1      5     1       50     500ms\n" +
+		"users  2      15    0       300    1.5s\n" +
+		"TOTAL  3      20    1       350    2s\n"
+	if b.String() != expected {
+		t.Errorf("Got\n%s", b.String())
+	}
+}
+
+func TestPrintFailures(t *testing.T) {
+	s := New()
+	s.Add(Table{Name: "users", Files: 1, Rows: 15, Bytes: 300, Duration: time.Second})
+	s.Fail("log", errors.New("connection lost"))
+	b := &bytes.Buffer{}
+	if err := s.Print(b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"TABLE  FILES  ROWS  BYTES  TIME  ERRORS\n" +
+		"log    0      0     0      0s    1\n" +
+		"users  1      15    300    1s    0\n" +
+		"TOTAL  1      15    300    1s    1\n" +
+		"1 errors:\n" +
+		"  log: connection lost\n"
+	if b.String() != expected {
+		t.Errorf("Got\n%s", b.String())
+	}
+}
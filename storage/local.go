@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Local stores files in a local directory
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: filepath.Clean(dir)}
+}
+
+// Create creates the file which is synced to disk on close, so it's never renamed into place before its data is written
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	f, err := os.Create(l.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return syncedFile{f}, nil
+}
+
+type syncedFile struct {
+	*os.File
+}
+
+func (f syncedFile) Close() error {
+	if err := f.Sync(); err != nil {
+		f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(l.Path(name))
+}
+
+func (l *Local) List(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(l.Path(pattern))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = filepath.Base(p)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (l *Local) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(l.Path(name))
+}
+
+func (l *Local) Remove(name string) error {
+	return os.Remove(l.Path(name))
+}
+
+func (l *Local) RemoveAll(name string) error {
+	return os.RemoveAll(l.Path(name))
+}
+
+// Rename renames the file and syncs the directory, so the new name survives a crash
+func (l *Local) Rename(oldName, newName string) error {
+	if err := os.Rename(l.Path(oldName), l.Path(newName)); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(l.Path(newName)))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.dir, name)
+}
+
+func (l *Local) Close() error {
+	return nil
+}
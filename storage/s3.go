@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// s3PartSize is the size of parts uploaded while a file is written, a part is buffered in memory.
+	// S3 allows up to 10000 parts, so files are limited to 640GB
+	s3PartSize = 64 << 20
+	// s3Retries is how many times a broken download is resumed from where it stopped
+	s3Retries = 3
+)
+
+// s3CopySize is the largest object copied by a single request, larger ones are copied part by part
+var s3CopySize int64 = 5 << 30
+
+// S3 stores files as objects under a prefix in an S3 bucket
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns the storage at s3://bucket/prefix on AWS or an S3 compatible server at options.S3Endpoint.
+// Credentials are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (or MINIO_ROOT_USER and MINIO_ROOT_PASSWORD),
+// ~/.aws/credentials or the instance IAM role
+func NewS3(location string, options Options) (*S3, error) {
+	bucket, prefix, err := parseS3(location)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, secure, err := parseEndpoint(options.S3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		}),
+		Secure: secure,
+		Region: options.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// fail early when the bucket can't be reached
+	exists, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %q does not exist", bucket)
+	}
+	return &S3{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// parseS3 returns the bucket and the key prefix of s3://bucket/prefix, the prefix ends with / unless it's empty
+func parseS3(location string) (string, string, error) {
+	where, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if where.Host == "" {
+		return "", "", errors.New("bucket name expected")
+	}
+	prefix := strings.Trim(where.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return where.Host, prefix, nil
+}
+
+// parseEndpoint returns host[:port] of the endpoint URL and whether to use https,
+// the scheme is required as S3 compatible servers often listen on plain http
+func parseEndpoint(endpoint string) (string, bool, error) {
+	if endpoint == "" {
+		return "s3.amazonaws.com", true, nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return "", false, fmt.Errorf("s3 endpoint %q must be http:// or https:// URL", endpoint)
+	}
+	where, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, err
+	}
+	if where.Host == "" {
+		return "", false, fmt.Errorf("s3 endpoint %q has no host", endpoint)
+	}
+	return where.Host, where.Scheme == "https", nil
+}
+
+// Create uploads the file by parts while it's written, the object appears when the file is closed
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	r, w := io.Pipe()
+	upload := &s3Upload{PipeWriter: w, done: make(chan error, 1)}
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), r, -1, minio.PutObjectOptions{
+			PartSize:    s3PartSize,
+			ContentType: "application/octet-stream",
+		})
+		// unblock the writer when the upload fails
+		r.CloseWithError(err)
+		upload.done <- err
+	}()
+	return upload, nil
+}
+
+// errUploadAborted fails the upload of a file which is aborted
+var errUploadAborted = errors.New("upload aborted")
+
+// s3Upload is the file being uploaded, Close waits for the upload to complete
+type s3Upload struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (u *s3Upload) Close() error {
+	u.PipeWriter.Close()
+	return <-u.done
+}
+
+// Abort fails the upload, so no object is created and parts uploaded so far are removed
+func (u *s3Upload) Abort() error {
+	u.PipeWriter.CloseWithError(errUploadAborted)
+	if err := <-u.done; err != nil && err != errUploadAborted {
+		return err
+	}
+	return nil
+}
+
+// Open downloads the file while it's read, a broken download is resumed from where it stopped
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	d := &s3Download{s3: s, key: s.key(name)}
+	if err := d.open(); err != nil {
+		return nil, s.error("open", name, err)
+	}
+	return d, nil
+}
+
+type s3Download struct {
+	s3      *S3
+	key     string
+	object  *minio.Object
+	offset  int64
+	retries int
+}
+
+// open requests the rest of the object starting at the offset
+func (d *s3Download) open() error {
+	object, err := d.s3.client.GetObject(context.Background(), d.s3.bucket, d.key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	// the request is sent on the first call, so errors show up here rather than on read
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return err
+	}
+	// a range set in the options is dropped by Stat, seeking makes the next read request the rest of the object
+	if d.offset > 0 {
+		if _, err := object.Seek(d.offset, io.SeekStart); err != nil {
+			object.Close()
+			return err
+		}
+	}
+	d.object = object
+	return nil
+}
+
+func (d *s3Download) Read(p []byte) (int, error) {
+	n, err := d.object.Read(p)
+	d.offset += int64(n)
+	if err == nil || err == io.EOF || d.retries >= s3Retries {
+		return n, err
+	}
+	d.retries++
+	log.Printf("Warning: download of %s broken at %d bytes, resuming: %s", d.key, d.offset, err)
+	d.object.Close()
+	if openErr := d.open(); openErr != nil {
+		return n, openErr
+	}
+	return n, nil
+}
+
+func (d *s3Download) Close() error {
+	return d.object.Close()
+}
+
+// List returns files and directories directly under the prefix, the pattern is matched against their names
+func (s *S3) List(pattern string) ([]string, error) {
+	// names can only start with the part of the pattern before the first special character
+	literal := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		literal = pattern[:i]
+	}
+	var names []string
+	for object := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s.prefix + literal}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// keys of directories end with the delimiter
+		name := strings.TrimSuffix(strings.TrimPrefix(object.Key, s.prefix), "/")
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *S3) Stat(name string) (os.FileInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.error("stat", name, err)
+	}
+	return &s3FileInfo{name: name, size: info.Size, modTime: info.LastModified}, nil
+}
+
+// Remove removes the object, removing a missing object is not an error on S3
+func (s *S3) Remove(name string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+// RemoveAll removes the object and all objects under it as a directory
+func (s *S3) RemoveAll(name string) error {
+	objects := s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s.key(name) + "/", Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+		if err := s.client.RemoveObject(context.Background(), s.bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return s.Remove(name)
+}
+
+// Rename copies the object on the server and removes the old one, S3 can't rename objects
+func (s *S3) Rename(oldName, newName string) error {
+	info, err := s.Stat(oldName)
+	if err != nil {
+		return err
+	}
+	src := minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldName)}
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newName)}
+	if info.Size() <= s3CopySize {
+		_, err = s.client.CopyObject(context.Background(), dst, src)
+	} else {
+		_, err = s.client.ComposeObject(context.Background(), dst, src)
+	}
+	if err != nil {
+		return err
+	}
+	return s.Remove(oldName)
+}
+
+func (s *S3) Path(name string) string {
+	return "s3://" + s.bucket + "/" + s.key(name)
+}
+
+func (s *S3) Close() error {
+	return nil
+}
+
+func (s *S3) key(name string) string {
+	return s.prefix + name
+}
+
+// error makes errors about missing objects satisfy os.IsNotExist
+func (s *S3) error(op, name string, err error) error {
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NotFound" {
+		return &os.PathError{Op: op, Path: s.Path(name), Err: os.ErrNotExist}
+	}
+	return err
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) Mode() os.FileMode  { return 0644 }
+func (i *s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i *s3FileInfo) IsDir() bool        { return false }
+func (i *s3FileInfo) Sys() interface{}   { return nil }
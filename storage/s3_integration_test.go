@@ -0,0 +1,66 @@
+//go:build integration
+// +build integration
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestS3Integration runs against a real S3 compatible server, e.g. a local MinIO:
+//
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin S3_TEST_ENDPOINT=http://localhost:9000 \
+//	S3_TEST_BUCKET=test go test -tags integration ./storage
+func TestS3Integration(t *testing.T) {
+	bucket := os.Getenv("S3_TEST_BUCKET")
+	if bucket == "" {
+		t.Skip("S3_TEST_BUCKET is not set")
+	}
+	location := fmt.Sprintf("s3://%s/test-%d", bucket, time.Now().UnixNano())
+	b, err := New(location, Options{S3Endpoint: os.Getenv("S3_TEST_ENDPOINT"), S3Region: os.Getenv("S3_TEST_REGION")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		names, _ := b.List("*")
+		for _, name := range names {
+			b.Remove(name)
+		}
+		b.Close()
+	}()
+	// directories are just prefixes of keys
+	testBackend(t, b, func(string) error { return nil })
+
+	// larger than a part, so it's uploaded by parts
+	data := bytes.Repeat([]byte("0123456789abcdef"), s3PartSize/16+1)
+	w, err := b.Create("large.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Rename("large.tmp", "large"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.Open("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("Expected %d bytes back, got %d", len(data), len(read))
+	}
+}
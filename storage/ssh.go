@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// PasswordEnv holds the sftp password when the URL has none
+	PasswordEnv = "SFTP_PASSWORD"
+	// PassphraseEnv holds the passphrase of identity files
+	PassphraseEnv = "SFTP_PASSPHRASE"
+)
+
+// sshHost is where and how to connect to, with ~/.ssh/config applied
+type sshHost struct {
+	address       string
+	user          string
+	password      string
+	identityFiles []string
+}
+
+// resolveSSH applies ~/.ssh/config settings for the URL host, settings of the URL itself win
+func resolveSSH(where *url.URL, config *ssh_config.Config, options Options) (*sshHost, error) {
+	alias := where.Hostname()
+	get := func(key string) string {
+		if config == nil {
+			return ""
+		}
+		value, _ := config.Get(alias, key)
+		return value
+	}
+	host := &sshHost{}
+	hostName := alias
+	if value := get("HostName"); value != "" {
+		hostName = value
+	}
+	port := where.Port()
+	if port == "" {
+		port = get("Port")
+	}
+	if port == "" {
+		port = "22"
+	}
+	host.address = net.JoinHostPort(hostName, port)
+	if where.User != nil {
+		host.user = where.User.Username()
+		host.password, _ = where.User.Password()
+	}
+	if host.user == "" {
+		host.user = get("User")
+	}
+	if host.user == "" {
+		// Try to figure out user name
+		if userName := os.Getenv("USER"); userName != "" {
+			host.user = userName
+		} else if currentUser, err := user.Current(); err == nil {
+			host.user = currentUser.Username
+		} else {
+			return nil, errors.New("user name expected")
+		}
+	}
+	if host.password == "" {
+		host.password = os.Getenv(PasswordEnv)
+	}
+	if options.IdentityFile != "" {
+		file := expandHome(options.IdentityFile)
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		host.identityFiles = append(host.identityFiles, file)
+	}
+	if config != nil {
+		files, _ := config.GetAll(alias, "IdentityFile")
+		for _, file := range files {
+			// files from the config are only tried when they exist, like ssh does
+			if file = expandHome(file); fileExists(file) {
+				host.identityFiles = append(host.identityFiles, file)
+			}
+		}
+	}
+	return host, nil
+}
+
+// clientConfig returns the ssh client config: agent keys and identity files, then password, are tried in turn.
+// Agent keys sign through the returned agent connection, it must be kept open while connections are made, it's nil without agent
+func (h *sshHost) clientConfig(options Options) (config *ssh.ClientConfig, agentConn io.Closer, err error) {
+	var signers []ssh.Signer
+	if aConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+		if agentSigners, err := agent.NewClient(aConn).Signers(); err == nil {
+			signers = append(signers, agentSigners...)
+			agentConn = aConn
+		} else {
+			aConn.Close()
+		}
+	}
+	defer func() {
+		if err != nil && agentConn != nil {
+			agentConn.Close()
+			agentConn = nil
+		}
+	}()
+	for _, file := range h.identityFiles {
+		signer, err := loadKey(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+	var authenticationMethods []ssh.AuthMethod
+	if len(signers) > 0 {
+		authenticationMethods = append(authenticationMethods, ssh.PublicKeys(signers...))
+	}
+	if h.password != "" {
+		authenticationMethods = append(authenticationMethods, ssh.Password(h.password))
+	}
+	config = &ssh.ClientConfig{
+		User: h.user,
+		Auth: authenticationMethods,
+	}
+	if options.InsecureHostKey {
+		log.Printf("Warning: host key of %s is not checked", h.address)
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return config, agentConn, nil
+	}
+	knownHosts := options.KnownHosts
+	if knownHosts == "" {
+		knownHosts = filepath.Join(homeDir(), ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(expandHome(knownHosts))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading known hosts: %s", err)
+	}
+	algorithms := knownAlgorithms(callback, h.address)
+	if len(algorithms) == 0 {
+		return nil, nil, fmt.Errorf("host %s is not in %s, add it with ssh-keyscan or connect with ssh once", h.address, knownHosts)
+	}
+	config.HostKeyCallback = callback
+	// the server must offer a key of a type we know, otherwise it can't be checked
+	config.HostKeyAlgorithms = algorithms
+	return config, agentConn, nil
+}
+
+// knownAlgorithms returns the host key algorithms matching the keys known for the address
+func knownAlgorithms(callback ssh.HostKeyCallback, address string) []string {
+	// a key which is never known makes the callback list the ones which are
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	keyErr, ok := callback(address, &net.TCPAddr{IP: net.IPv4zero}, probe).(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+// loadKey reads the private key, the passphrase is taken from the environment when the key needs one
+func loadKey(file string) (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("identity file %s is protected by a passphrase, set it in %s", file, PassphraseEnv)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity file %s: %s", file, err)
+	}
+	return signer, nil
+}
+
+// readSSHConfig reads ~/.ssh/config, it's nil when there's none
+func readSSHConfig() (*ssh_config.Config, error) {
+	f, err := os.Open(filepath.Join(homeDir(), ".ssh", "config"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ssh_config.Decode(f)
+}
+
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	if currentUser, err := user.Current(); err == nil {
+		return currentUser.HomeDir
+	}
+	return ""
+}
+
+func expandHome(file string) string {
+	if file == "~" || strings.HasPrefix(file, "~/") {
+		return filepath.Join(homeDir(), file[1:])
+	}
+	return file
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Backend is a directory where backup files are stored, file names are relative to it.
+// Errors about missing files satisfy os.IsNotExist
+type Backend interface {
+	// Create creates or truncates the file
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	// List returns names of files matching the shell pattern, sorted. Names in the pattern are escaped by EscapeGlob
+	List(pattern string) ([]string, error)
+	Stat(name string) (os.FileInfo, error)
+	Remove(name string) error
+	// RemoveAll removes the file or the directory with everything in it, a missing file is not an error
+	RemoveAll(name string) error
+	// Rename renames the file, replacing the new one if it exists
+	Rename(oldName, newName string) error
+	// Path returns the location of the file, for logs and commands
+	Path(name string) string
+	Close() error
+}
+
+// globSpecial are characters having a meaning in shell patterns
+var globSpecial = regexp.MustCompile(`[*?[\\]`)
+
+// EscapeGlob escapes the name to be matched literally by List
+func EscapeGlob(name string) string {
+	return globSpecial.ReplaceAllString(name, `\$0`)
+}
+
+// aborter is a file being written which can be discarded rather than completed by Close
+type aborter interface {
+	Abort() error
+}
+
+// Abort discards the file being written where the backend can do it, such as an S3 upload,
+// other files are closed with what was written so far
+func Abort(w io.WriteCloser) error {
+	if a, ok := w.(aborter); ok {
+		return a.Abort()
+	}
+	return w.Close()
+}
+
+// Options configure remote backends
+type Options struct {
+	// Create creates the directory when it does not exist
+	Create bool
+	// Connections is how many files are expected to be open at once, usually the number of streams
+	Connections int
+	// KnownHosts is the file to check sftp host keys against, ~/.ssh/known_hosts by default
+	KnownHosts string
+	// InsecureHostKey disables the host key check
+	InsecureHostKey bool
+	// IdentityFile is the private key to authenticate by, besides ssh agent and IdentityFile of ~/.ssh/config
+	IdentityFile string
+	// S3Endpoint is http:// or https:// URL of an S3 compatible server, AWS by default
+	S3Endpoint string
+	// S3Region is the region of the bucket, detected when empty
+	S3Region string
+}
+
+// New returns the backend for the location by its scheme: file://, sftp:// and s3:// URLs, or a local path
+func New(location string, options Options) (Backend, error) {
+	switch {
+	case strings.HasPrefix(location, "sftp://"):
+		return NewSFTP(location, options)
+	case strings.HasPrefix(location, "s3://"):
+		return NewS3(location, options)
+	case strings.HasPrefix(location, "file://"):
+		location = strings.TrimPrefix(location, "file://")
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("unsupported storage %q", location)
+	}
+	l := NewLocal(location)
+	if options.Create {
+		if err := os.MkdirAll(l.dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+func TestNew(t *testing.T) {
+	for location, expected := range map[string]string{
+		"/backups/db":        "/backups/db/a",
+		"file:///backups/db": "/backups/db/a",
+		"backups/":           "backups/a",
+	} {
+		b, err := New(location, Options{Connections: 1})
+		if err != nil {
+			t.Errorf("%s: %s", location, err)
+			continue
+		}
+		if path := b.Path("a"); path != expected {
+			t.Errorf("%s: expected %q, got %q", location, expected, path)
+		}
+	}
+	if _, err := New("ftp://host/backups", Options{}); err == nil {
+		t.Error("Expected error for unsupported scheme")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testBackend(t, NewLocal(dir), func(name string) error { return os.Mkdir(filepath.Join(dir, name), 0755) })
+}
+
+func TestEscapeGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := NewLocal(dir)
+	for _, name := range []string{"a[1].csjson", "a1.csjson", "a*.csjson", "ab.csjson"} {
+		w, err := l.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, expected := range map[string][]string{
+		"a[1]": {"a[1].csjson"},
+		"a*":   {"a*.csjson"},
+	} {
+		if names, err := l.List(EscapeGlob(name) + ".*"); err != nil || !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected %v, got %v, %v", name, expected, names, err)
+		}
+	}
+}
+
+// inMemSFTP returns the storage on an in-memory sftp server and counts connections made to it
+func inMemSFTP(t *testing.T, size int, dials *int) *SFTP {
+	handlers := sftp.InMemHandler()
+	var mu sync.Mutex
+	s := newSFTP("/backups", size, func() (*sftpConn, error) {
+		mu.Lock()
+		*dials++
+		mu.Unlock()
+		serverConn, clientConn := net.Pipe()
+		server := sftp.NewRequestServer(serverConn, handlers)
+		go server.Serve()
+		client, err := sftp.NewClientPipe(clientConn, clientConn)
+		if err != nil {
+			return nil, err
+		}
+		return newSFTPConn(client, server), nil
+	})
+	if err := s.do(func(client *sftp.Client) error { return client.Mkdir("/backups") }); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSFTP(t *testing.T) {
+	dials := 0
+	s := inMemSFTP(t, 1, &dials)
+	defer s.Close()
+	testBackend(t, s, func(name string) error {
+		return s.do(func(client *sftp.Client) error { return client.Mkdir(s.path(name)) })
+	})
+	if dials != 1 {
+		t.Errorf("Expected a single connection, got %d", dials)
+	}
+}
+
+func TestSFTPPool(t *testing.T) {
+	dials := 0
+	s := inMemSFTP(t, 2, &dials)
+	// three files open at once need three connections, only two of them are kept
+	var files []io.WriteCloser
+	for _, name := range []string{"a", "b", "c"} {
+		f, err := s.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dials != 3 || len(s.idle) != 2 {
+		t.Errorf("Expected 3 connections with 2 idle, got %d with %d idle", dials, len(s.idle))
+	}
+
+	// dropped connections are replaced by a new one
+	s.idle[0].client.Close()
+	s.idle[1].client.Close()
+	if _, err := s.Stat("a"); err != nil {
+		t.Fatal(err)
+	}
+	if dials != 4 {
+		t.Errorf("Expected a new connection, got %d", dials)
+	}
+
+	idle := append([]*sftpConn{}, s.idle...)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range idle {
+		if c.alive() {
+			t.Error("Expected connections closed")
+		}
+	}
+	if _, err := s.Stat("a"); err == nil {
+		t.Error("Expected error after close")
+	}
+}
+
+// closer records it was closed
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSFTPCloseAgent(t *testing.T) {
+	dials := 0
+	s := inMemSFTP(t, 1, &dials)
+	agentConn := &closer{}
+	s.agent = agentConn
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !agentConn.closed {
+		t.Error("Expected the agent connection closed with the storage")
+	}
+}
+
+func TestSFTPPingIdle(t *testing.T) {
+	dials := 0
+	s := inMemSFTP(t, 2, &dials)
+	defer s.Close()
+	busy, err := s.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	// idle connections: one alive, one broken
+	for i := 0; i < 2; i++ {
+		c, err := s.dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.put(c)
+	}
+	s.idle[1].client.Close()
+	s.pingIdle()
+	if len(s.idle) != 1 || !s.idle[0].alive() {
+		t.Errorf("Expected the broken connection dropped, got %d idle", len(s.idle))
+	}
+	// the busy connection is not pinged, so the transfer goes on
+	if _, err := busy.Write([]byte("data")); err != nil {
+		t.Errorf("Expected the busy connection kept, got %s", err)
+	}
+}
+
+// plainRenamer is an sftp server without posix-rename extension
+type plainRenamer struct {
+	files map[string]string
+}
+
+func (r *plainRenamer) HasExtension(name string) (string, bool) { return "", false }
+
+func (r *plainRenamer) PosixRename(oldName, newName string) error {
+	return errors.New("posix-rename is not supported")
+}
+
+func (r *plainRenamer) Rename(oldName, newName string) error {
+	if _, ok := r.files[newName]; ok {
+		return errors.New("file exists")
+	}
+	r.files[newName] = r.files[oldName]
+	delete(r.files, oldName)
+	return nil
+}
+
+func (r *plainRenamer) Remove(name string) error {
+	if _, ok := r.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(r.files, name)
+	return nil
+}
+
+func TestRenameWithoutPosixRename(t *testing.T) {
+	r := &plainRenamer{files: map[string]string{"a.tmp": "new", "a": "old", "b.tmp": "b"}}
+	if err := rename(r, "a.tmp", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rename(r, "b.tmp", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"a": "new", "b": "b"}; !reflect.DeepEqual(r.files, expected) {
+		t.Errorf("Expected %v, got %v", expected, r.files)
+	}
+}
+
+// testBackend checks the backend, mkdir creates a directory where the backend has directories
+func testBackend(t *testing.T, b Backend, mkdir func(name string) error) {
+	write := func(name, data string) {
+		w, err := b.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		r, err := b.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	write("orders.00002.csjson", "2")
+	write("orders.00001.csjson", "1")
+	write("users.csjson", "old")
+	write("users.csjson.tmp", "new")
+
+	names, err := b.List("orders.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"orders.00001.csjson", "orders.00002.csjson"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+	if err := b.Rename("users.csjson.tmp", "users.csjson"); err != nil {
+		t.Fatal(err)
+	}
+	if data := read("users.csjson"); data != "new" {
+		t.Errorf("Expected renamed file to replace the old one, got %q", data)
+	}
+	if info, err := b.Stat("orders.00001.csjson"); err != nil || info.Size() != 1 {
+		t.Errorf("Got %v, %v", info, err)
+	}
+	if err := b.Remove("orders.00001.csjson"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat("orders.00001.csjson"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+	if _, err := b.Open("missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+
+	// sets of files are kept in directories
+	if err := mkdir("set"); err != nil {
+		t.Fatal(err)
+	}
+	write("set/manifest.json", "{}")
+	if names, err := b.List("s*"); err != nil || !reflect.DeepEqual(names, []string{"set"}) {
+		t.Errorf("Expected the directory listed, got %v, %v", names, err)
+	}
+	if data := read("set/manifest.json"); data != "{}" {
+		t.Errorf("Got %q", data)
+	}
+	if err := b.RemoveAll("set"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat("set/manifest.json"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+	if err := b.RemoveAll("missing"); err != nil {
+		t.Errorf("Expected no error removing missing directory, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package table_dumper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Chunk is a part of a table selected by a primary key range, a chunk without Where is the whole table
+type Chunk struct {
+	Table  string
+	Number int
+	Where  string
+	Args   []interface{}
+	Bytes  int64 // estimated size, for scheduling
+}
+
+// Size returns estimated size of the chunk, so the largest chunks are dumped first
+func (c Chunk) Size() int64 {
+	return c.Bytes
+}
+
+// FileName returns the data file name of the chunk without suffix
+func (c Chunk) FileName() string {
+	if c.Number == 0 {
+		return c.Table
+	}
+	return fmt.Sprintf("%s.%05d", c.Table, c.Number)
+}
+
+func (c Chunk) String() string {
+	if c.Number == 0 {
+		return c.Table
+	}
+	return fmt.Sprintf("%s#%d", c.Table, c.Number)
+}
+
+// Split divides the table into chunks of about rowsPerChunk rows by its primary key.
+// When the leading key column is an integer the ranges are computed from its minimum and maximum,
+// otherwise chunk boundaries are found by walking the key
+func Split(ctx context.Context, conn sqlx.QueryerContext, table string, pk []string, integer bool, estimatedRows, rowsPerChunk int64) ([]Chunk, error) {
+	if rowsPerChunk <= 0 || len(pk) == 0 || estimatedRows <= rowsPerChunk {
+		return []Chunk{{Table: table}}, nil
+	}
+	if integer {
+		return splitInteger(ctx, conn, table, pk[0], (estimatedRows+rowsPerChunk-1)/rowsPerChunk)
+	}
+	return splitByKey(ctx, conn, table, pk, rowsPerChunk)
+}
+
+func splitInteger(ctx context.Context, conn sqlx.QueryerContext, table, column string, chunks int64) ([]Chunk, error) {
+	var min, max sql.NullString
+	query := fmt.Sprintf("SELECT MIN(`%[1]s`), MAX(`%[1]s`) FROM `%[2]s`", column, table)
+	if err := conn.QueryRowxContext(ctx, query).Scan(&min, &max); err != nil {
+		return nil, err
+	}
+	if !min.Valid || !max.Valid {
+		return []Chunk{{Table: table}}, nil
+	}
+	lo, ok := new(big.Int).SetString(min.String, 10)
+	if !ok {
+		return nil, fmt.Errorf("unexpected minimum %q of `%s`.`%s`", min.String, table, column)
+	}
+	hi, ok := new(big.Int).SetString(max.String, 10)
+	if !ok {
+		return nil, fmt.Errorf("unexpected maximum %q of `%s`.`%s`", max.String, table, column)
+	}
+	boundaries := integerBoundaries(lo, hi, chunks)
+	if len(boundaries) == 0 {
+		return []Chunk{{Table: table}}, nil
+	}
+	// the first and the last ranges are open, literals are safe to inline as they are parsed integers
+	result := make([]Chunk, 0, len(boundaries)+1)
+	lower := ""
+	for _, upper := range append(boundaries, "") {
+		var conditions []string
+		if lower != "" {
+			conditions = append(conditions, fmt.Sprintf("`%s` >= %s", column, lower))
+		}
+		if upper != "" {
+			conditions = append(conditions, fmt.Sprintf("`%s` < %s", column, upper))
+		}
+		result = append(result, Chunk{
+			Table:  table,
+			Number: len(result) + 1,
+			Where:  strings.Join(conditions, " AND "),
+		})
+		lower = upper
+	}
+	return result, nil
+}
+
+// integerBoundaries divides [lo, hi] into equal ranges and returns the lower bounds of all ranges but the first
+func integerBoundaries(lo, hi *big.Int, chunks int64) []string {
+	// step = ceil((hi - lo + 1) / chunks)
+	span := new(big.Int).Sub(hi, lo)
+	span.Add(span, big.NewInt(1))
+	step := new(big.Int).Add(span, big.NewInt(chunks-1))
+	step.Div(step, big.NewInt(chunks))
+	var boundaries []string
+	for b := new(big.Int).Add(lo, step); b.Cmp(hi) <= 0; b.Add(b, step) {
+		boundaries = append(boundaries, b.String())
+	}
+	return boundaries
+}
+
+func splitByKey(ctx context.Context, conn sqlx.QueryerContext, table string, pk []string, rowsPerChunk int64) ([]Chunk, error) {
+	quoted := make([]string, len(pk))
+	for i, column := range pk {
+		quoted[i] = "`" + column + "`"
+	}
+	columns := strings.Join(quoted, ", ")
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(pk)), ", ")
+	tuple := "(" + columns + ")"
+	var (
+		result []Chunk
+		lower  []interface{}
+	)
+	for {
+		query := "SELECT " + columns + " FROM `" + table + "`"
+		if lower != nil {
+			query += " WHERE " + tuple + " >= (" + placeholders + ")"
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT 1 OFFSET %d", columns, rowsPerChunk)
+		row, err := conn.QueryRowxContext(ctx, query, lower...).SliceScan()
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// bind key values as strings, so they are compared using the column collation
+		upper := make([]interface{}, len(row))
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				upper[i] = string(b)
+			} else {
+				upper[i] = v
+			}
+		}
+		chunk := Chunk{Table: table, Number: len(result) + 1}
+		if lower != nil {
+			chunk.Where = tuple + " >= (" + placeholders + ") AND "
+			chunk.Args = append(chunk.Args, lower...)
+		}
+		chunk.Where += tuple + " < (" + placeholders + ")"
+		chunk.Args = append(chunk.Args, upper...)
+		result = append(result, chunk)
+		lower = upper
+	}
+	if len(result) == 0 {
+		return []Chunk{{Table: table}}, nil
+	}
+	result = append(result, Chunk{
+		Table:  table,
+		Number: len(result) + 1,
+		Where:  tuple + " >= (" + placeholders + ")",
+		Args:   lower,
+	})
+	return result, nil
+}
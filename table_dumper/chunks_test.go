@@ -0,0 +1,187 @@
+package table_dumper
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestIntegerBoundaries(t *testing.T) {
+	cases := []struct {
+		lo, hi   string
+		chunks   int64
+		expected []string
+	}{
+		{"1", "100", 4, []string{"26", "51", "76"}},
+		{"1", "10", 3, []string{"5", "9"}},
+		{"5", "5", 3, nil},
+		{"-10", "9", 2, []string{"0"}},
+		{"0", "18446744073709551615", 2, []string{"9223372036854775808"}},
+	}
+	for _, c := range cases {
+		lo, _ := new(big.Int).SetString(c.lo, 10)
+		hi, _ := new(big.Int).SetString(c.hi, 10)
+		if got := integerBoundaries(lo, hi, c.chunks); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("[%s, %s] in %d chunks: expected %v, got %v", c.lo, c.hi, c.chunks, c.expected, got)
+		}
+	}
+}
+
+func TestChunkFileName(t *testing.T) {
+	if name := (Chunk{Table: "users"}).FileName(); name != "users" {
+		t.Errorf("Got %q", name)
+	}
+	if name := (Chunk{Table: "users", Number: 12}).FileName(); name != "users.00012" {
+		t.Errorf("Got %q", name)
+	}
+}
+
+// keyRow is a row of the fake table keyed by (name, n)
+type keyRow struct {
+	name  string
+	n     int64
+	price float64
+}
+
+var keyRows = []keyRow{{"a", 1, 1.5}, {"a", 2, 0.1}, {"b", 1, 2}, {"c", 5, 1e-7}}
+
+func (r keyRow) less(name string, n int64) bool {
+	return r.name < name || r.name == name && r.n < n
+}
+
+// fakeConn answers split and dump queries over keyRows. Queries with arguments return typed values
+// like the binary protocol of a prepared statement does, other queries return text
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := keyRows
+	where := ""
+	if i := strings.Index(query, " WHERE "); i >= 0 {
+		where = query[i+len(" WHERE "):]
+		if j := strings.Index(where, " ORDER BY "); j >= 0 {
+			where = where[:j]
+		}
+	}
+	// conditions are (`name`, `n`) >= (?, ?) or (`name`, `n`) < (?, ?)
+	next := args
+	for _, condition := range strings.Split(where, " AND ") {
+		if condition == "" {
+			continue
+		}
+		name := next[0].Value.(string)
+		n, err := strconv.ParseInt(fmt.Sprint(next[1].Value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		next = next[2:]
+		var selected []keyRow
+		for _, r := range rows {
+			if r.less(name, n) == strings.Contains(condition, " < ") {
+				selected = append(selected, r)
+			}
+		}
+		rows = selected
+	}
+	columns := []string{"name", "n", "price"}
+	if strings.HasPrefix(query, "SELECT `name`, `n`") {
+		columns = columns[:2]
+		if i := strings.Index(query, " OFFSET "); i >= 0 {
+			offset, err := strconv.Atoi(query[i+len(" OFFSET "):])
+			if err != nil {
+				return nil, err
+			}
+			if offset >= len(rows) {
+				rows = nil
+			} else {
+				rows = rows[offset : offset+1]
+			}
+		}
+	}
+	result := &fakeRows{columns: columns}
+	for _, r := range rows {
+		values := []driver.Value{[]byte(r.name), r.n, r.price}
+		if len(args) == 0 {
+			values = []driver.Value{[]byte(r.name), []byte(strconv.FormatInt(r.n, 10)), []byte(strconv.FormatFloat(r.price, 'g', -1, 64))}
+		}
+		result.rows = append(result.rows, values[:len(columns)])
+	}
+	return result, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+// keyConfig is the column kinds of the fake table
+type keyConfig struct{}
+
+func (keyConfig) HasBackupLock() bool { return false }
+
+func (keyConfig) TableColumnType(table string, col int) string {
+	return []string{"string", "numeric", "numeric"}[col]
+}
+
+func TestSplitByKey(t *testing.T) {
+	sql.Register("fake_binary", fakeDriver{})
+	conn, err := sqlx.Open("fake_binary", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+	chunks, err := Split(ctx, conn, "prices", []string{"name", "n"}, false, int64(len(keyRows)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || !reflect.DeepEqual(chunks[0].Args, []interface{}{"b", "1"}) {
+		t.Fatalf("Got %+v", chunks)
+	}
+
+	// rows of chunks read by a prepared statement are dumped the same as the whole table
+	dump := func(chunk Chunk) string {
+		var b bytes.Buffer
+		if _, err := NewTableDumper("", "prices", keyConfig{}).WithChunk(chunk).Run(ctx, &b, conn); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+	whole := dump(Chunk{Table: "prices"})
+	if expected := "\"a\",1,1.5\n\"a\",2,0.1\n\"b\",1,2\n\"c\",5,1e-07\n"; whole != expected {
+		t.Errorf("Expected %q, got %q", expected, whole)
+	}
+	if chunked := dump(chunks[0]) + dump(chunks[1]); chunked != whole {
+		t.Errorf("Expected %q, got %q", whole, chunked)
+	}
+}
@@ -1,12 +1,15 @@
 package table_dumper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"strconv"
 	"time"
 
+	"github.com/BrightLocal/MySQLBackup/progress"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -31,6 +34,8 @@ type Dumper struct {
 	config     Config
 	w          io.Writer
 	withHeader bool
+	chunk      Chunk
+	progress   func(rows, bytes int)
 }
 
 func NewTableDumper(dsn, tableName string, config Config) *Dumper {
@@ -38,20 +43,37 @@ func NewTableDumper(dsn, tableName string, config Config) *Dumper {
 		dsn:       dsn,
 		tableName: tableName,
 		config:    config,
+		chunk:     Chunk{Table: tableName},
 	}
 }
 
+// WithChunk limits the dump to a primary key range of the table
+func (d *Dumper) WithChunk(chunk Chunk) *Dumper {
+	d.chunk = chunk
+	return d
+}
+
+// WithProgress sets a function to report rows and bytes dumped since its previous call
+func (d *Dumper) WithProgress(progress func(rows, bytes int)) *Dumper {
+	d.progress = progress
+	return d
+}
+
 func (d *Dumper) WithHeader(withHeader bool) *Dumper {
 	d.withHeader = withHeader
 	return d
 }
 
-func (d *Dumper) Run(w io.Writer, conn *sqlx.DB) (stats, error) {
+// Run dumps rows into the writer, the query is cancelled with the context
+func (d *Dumper) Run(ctx context.Context, w io.Writer, conn sqlx.QueryerContext) (stats, error) {
 	d.w = w
 	s := stats{}
-	log.Printf("Starting dumping table %q", d.tableName)
+	log.Printf("Starting dumping table %q", d.chunk)
 	query := fmt.Sprintf("SELECT * FROM `%s`", d.tableName)
-	result, err := conn.Queryx(query)
+	if d.chunk.Where != "" {
+		query += " WHERE " + d.chunk.Where
+	}
+	result, err := conn.QueryxContext(ctx, query, d.chunk.Args...)
 	if err != nil {
 		return s, err
 	}
@@ -68,6 +90,14 @@ func (d *Dumper) Run(w io.Writer, conn *sqlx.DB) (stats, error) {
 		}
 	}
 
+	reported := stats{}
+	report := func() {
+		if d.progress != nil {
+			d.progress(s.rows-reported.rows, s.bytes-reported.bytes)
+			reported = s
+		}
+	}
+	defer report()
 	for result.Next() {
 		row, err := result.SliceScan()
 		if err != nil {
@@ -79,9 +109,12 @@ func (d *Dumper) Run(w io.Writer, conn *sqlx.DB) (stats, error) {
 			return s, err
 		}
 		s.bytes += b
+		if s.rows%progress.ReportRows == 0 {
+			report()
+		}
 	}
 	s.duration = time.Now().Sub(start)
-	log.Printf("Finished dumping table %q (%d rows, %d bytes) in %s", d.tableName, s.Rows(), s.Bytes(), s.Duration().String())
+	log.Printf("Finished dumping table %q (%d rows, %d bytes) in %s", d.chunk, s.Rows(), s.Bytes(), s.Duration().String())
 	return s, nil
 }
 
@@ -106,34 +139,90 @@ func (d *Dumper) compactRow(row []interface{}) (int, error) {
 	var err error
 	for col, val := range row {
 		if val != nil {
-			switch d.config.TableColumnType(d.tableName, col) {
-			case "string":
-				out, err := json.Marshal(string(val.([]uint8)))
-				if err != nil {
-					log.Fatalf("Error marshaling value of column %d in table %s[%s]: %s", col, d.tableName, string(row[0].([]byte)), err)
-				}
-				b, err = d.w.Write(out)
-				n += b
-			case "binary":
-				out, err := json.Marshal(val)
-				if err != nil {
-					log.Fatalf("Error marshaling value of column %d in table %s[%s]: %s", col, d.tableName, string(row[0].([]byte)), err)
-				}
-				b, err = d.w.Write(out)
-				n += b
-			case "numeric":
-				b, err = d.w.Write([]byte(val.([]uint8)))
-				n += b
-			default:
-				log.Fatalf("Unsupported column type %q for %s:%d", d.config.TableColumnType(d.tableName, col), d.tableName, col)
+			kind := d.config.TableColumnType(d.tableName, col)
+			text, err := textValue(val)
+			if err != nil {
+				return n, fmt.Errorf("error encoding value of column %d in table %s: %s", col, d.tableName, err)
+			}
+			out, err := EncodeValue(kind, text)
+			if err != nil {
+				return n, fmt.Errorf("error encoding value of column %d in table %s: %s", col, d.tableName, err)
+			}
+			b, err = d.w.Write(out)
+			n += b
+			if err != nil {
+				return n, err
 			}
 		}
 		if col != len(row)-1 {
 			b, err = d.w.Write([]byte(","))
 			n += b
+			if err != nil {
+				return n, err
+			}
 		}
 	}
 	b, err = d.w.Write([]byte("\n"))
 	n += b
 	return n, err
 }
+
+// textValue returns the value as the text protocol sends it, values of prepared statements come typed
+func textValue(val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, v, 10), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	default:
+		return nil, fmt.Errorf("unsupported value %T", val)
+	}
+}
+
+// EncodeValue encodes a column value losslessly according to the column kind,
+// binary values become base64 strings as restorer tells them from text by the column type
+func EncodeValue(kind string, val []byte) ([]byte, error) {
+	switch kind {
+	case "string":
+		return json.Marshal(string(val))
+	case "binary":
+		return json.Marshal(val) // base64 string
+	case "numeric":
+		return numericLiteral(val), nil
+	case "bit":
+		if len(val) > 8 {
+			return nil, fmt.Errorf("bit value of %d bytes is too long", len(val))
+		}
+		var v uint64
+		for _, c := range val {
+			v = v<<8 | uint64(c)
+		}
+		return strconv.AppendUint(nil, v, 10), nil
+	default:
+		return nil, fmt.Errorf("unsupported column type %q", kind)
+	}
+}
+
+// numericLiteral strips leading zeros added by ZEROFILL, so the value is a valid JSON number
+func numericLiteral(val []byte) []byte {
+	sign := 0
+	if len(val) > 0 && (val[0] == '-' || val[0] == '+') {
+		sign = 1
+	}
+	i := sign
+	for i < len(val)-1 && val[i] == '0' && val[i+1] >= '0' && val[i+1] <= '9' {
+		i++
+	}
+	if i == sign {
+		return val
+	}
+	return append(append([]byte{}, val[:sign]...), val[i:]...)
+}
@@ -37,3 +37,38 @@ func TestWriteHeader(t *testing.T) {
 		t.Errorf("Got %q", out)
 	}
 }
+
+func TestEncodeValue(t *testing.T) {
+	cases := []struct {
+		kind     string
+		in       []byte
+		expected string
+	}{
+		{"string", []byte("multi line\nvalue"), `"multi line\nvalue"`},
+		{"string", []byte(`{"a": [1, 2]}`), `"{\"a\": [1, 2]}"`},
+		{"string", []byte("-838:59:59"), `"-838:59:59"`},
+		{"string", []byte("2019-03-01 02:00:00"), `"2019-03-01 02:00:00"`},
+		{"numeric", []byte("123"), `123`},
+		{"numeric", []byte("-12.50"), `-12.50`},
+		{"numeric", []byte("00042"), `42`},
+		{"numeric", []byte("0000"), `0`},
+		{"numeric", []byte("-0003.25"), `-3.25`},
+		{"numeric", []byte("0.5"), `0.5`},
+		{"numeric", []byte("1e+20"), `1e+20`},
+		{"bit", []byte{0x05}, `5`},
+		{"bit", []byte{0x01, 0x00}, `256`},
+		{"bit", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, `18446744073709551615`},
+		{"binary", []byte{0x00, 0x01, 0xff}, `"AAH/"`},
+	}
+	for _, c := range cases {
+		out, err := EncodeValue(c.kind, c.in)
+		if err != nil {
+			t.Errorf("%s %q: %s", c.kind, c.in, err)
+		} else if string(out) != c.expected {
+			t.Errorf("%s %q: expected %s, got %s", c.kind, c.in, c.expected, out)
+		}
+	}
+	if _, err := EncodeValue("hologram", []byte("x")); err == nil {
+		t.Error("Expected error for unknown kind")
+	}
+}
@@ -1,9 +1,93 @@
 package table_restorer
 
-import "strings"
+import (
+	"encoding/json"
+	"strings"
+)
 
-func Builder(in chan []string, out chan string) {
-	for row := range in {
-		out <- "(" + strings.Join(row, ", ") + ")"
+// maxPlaceholders is the limit of placeholders in a prepared statement
+const maxPlaceholders = 65535
+
+// valueOverhead is roughly how many bytes a bound value takes besides its data
+const valueOverhead = 11
+
+// batch collects rows for a multi-row INSERT, bounded by number of rows and their size
+type batch struct {
+	prefix   string
+	tuple    string
+	maxRows  int
+	maxBytes int
+	rows     [][]interface{}
+	bytes    int
+}
+
+func newBatch(tableName string, columns []string, maxRows, maxBytes int) *batch {
+	cols := make([]string, len(columns))
+	vals := make([]string, len(columns))
+	for i, col := range columns {
+		cols[i] = "`" + col + "`"
+		vals[i] = "?"
+	}
+	if len(columns) > 0 && maxRows*len(columns) > maxPlaceholders {
+		maxRows = maxPlaceholders / len(columns)
+	}
+	if maxRows < 1 {
+		maxRows = 1
+	}
+	return &batch{
+		prefix:   "INSERT INTO `" + tableName + "` (" + strings.Join(cols, ",") + ") VALUES ",
+		tuple:    "(" + strings.Join(vals, ",") + ")",
+		maxRows:  maxRows,
+		maxBytes: maxBytes,
+	}
+}
+
+// add appends the row unless it would not fit into the batch, a single row always fits into an empty batch
+func (b *batch) add(row []interface{}) bool {
+	size := rowSize(row)
+	if len(b.rows) > 0 && (len(b.rows) >= b.maxRows || b.bytes+size > b.maxBytes) {
+		return false
+	}
+	b.rows = append(b.rows, row)
+	b.bytes += size
+	return true
+}
+
+func (b *batch) len() int {
+	return len(b.rows)
+}
+
+// query returns the INSERT statement for all rows of the batch and its arguments
+func (b *batch) query() (string, []interface{}) {
+	tuples := make([]string, len(b.rows))
+	var args []interface{}
+	for i, row := range b.rows {
+		tuples[i] = b.tuple
+		args = append(args, row...)
+	}
+	return b.prefix + strings.Join(tuples, ","), args
+}
+
+func (b *batch) reset() {
+	b.rows = b.rows[:0]
+	b.bytes = 0
+}
+
+// rowSize estimates how many bytes the row takes in the statement packet
+func rowSize(row []interface{}) int {
+	size := 0
+	for _, value := range row {
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case json.Number:
+			size += len(v)
+		default:
+			size += 8
+		}
+		size += valueOverhead
 	}
+	return size
 }
@@ -0,0 +1,42 @@
+package table_restorer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	b := newBatch("t", []string{"id", "name"}, 3, 1000)
+	for i := 0; i < 3; i++ {
+		if !b.add([]interface{}{json.Number("1"), "name"}) {
+			t.Fatalf("Row %d expected to fit", i)
+		}
+	}
+	if b.add([]interface{}{json.Number("4"), "name"}) {
+		t.Error("Expected batch to be full by rows")
+	}
+	query, args := b.query()
+	if expected := "INSERT INTO `t` (`id`,`name`) VALUES (?,?),(?,?),(?,?)"; query != expected {
+		t.Errorf("Got %q", query)
+	}
+	if len(args) != 6 {
+		t.Errorf("Got %d args", len(args))
+	}
+	b.reset()
+	if b.len() != 0 {
+		t.Errorf("Got %d rows after reset", b.len())
+	}
+
+	b = newBatch("t", []string{"data"}, 100, 50)
+	if !b.add([]interface{}{string(make([]byte, 100))}) {
+		t.Error("Expected row larger than batch to fit into empty batch")
+	}
+	if b.add([]interface{}{"x"}) {
+		t.Error("Expected batch to be full by bytes")
+	}
+
+	b = newBatch("t", make([]string, 1000), 1000, 1<<30)
+	if b.maxRows != 65 {
+		t.Errorf("Got %d max rows", b.maxRows)
+	}
+}
@@ -0,0 +1,46 @@
+package table_restorer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// decodeRow converts parsed values back into what the columns hold, according to the column kinds
+func (r *Restorer) decodeRow(row []interface{}) error {
+	if len(row) != len(r.columns) {
+		return fmt.Errorf("column number in table %q mismatch, expected %d, got %d", r.tableName, len(r.columns), len(row))
+	}
+	for i, value := range row {
+		if value == nil || i >= len(r.kinds) {
+			continue
+		}
+		decoded, err := decodeValue(r.kinds[i], value)
+		if err != nil {
+			return fmt.Errorf("column %q of table %q: %s", r.columns[i], r.tableName, err)
+		}
+		row[i] = decoded
+	}
+	return nil
+}
+
+// decodeValue decodes a single value, numbers are kept as json.Number and bound to the insert with their exact text
+func decodeValue(kind string, value interface{}) (interface{}, error) {
+	switch kind {
+	case "binary":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected base64 string for binary value, got %T", value)
+		}
+		return base64.StdEncoding.DecodeString(s)
+	case "bit":
+		switch v := value.(type) {
+		case json.Number:
+			return strconv.ParseUint(string(v), 10, 64)
+		default:
+			return nil, fmt.Errorf("expected number for bit value, got %T", value)
+		}
+	}
+	return value, nil
+}
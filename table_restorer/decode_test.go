@@ -0,0 +1,91 @@
+package table_restorer
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/BrightLocal/MySQLBackup/table_dumper"
+)
+
+func TestDecodeValue(t *testing.T) {
+	v, err := decodeValue("binary", "AAH/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := v.([]byte); !ok || !bytes.Equal(b, []byte{0x00, 0x01, 0xff}) {
+		t.Errorf("Got %#v", v)
+	}
+	v, err = decodeValue("bit", json.Number("5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != uint64(5) {
+		t.Errorf("Got %#v", v)
+	}
+	if v, err := decodeValue("string", "AAH/"); err != nil || v != "AAH/" {
+		t.Errorf("Got %#v, %v", v, err)
+	}
+	if _, err := decodeValue("binary", "not base64!"); err == nil {
+		t.Error("Expected error for invalid base64")
+	}
+	if _, err := decodeValue("bit", "5"); err == nil {
+		t.Error("Expected error for string bit value")
+	}
+	v, err = decodeValue("bit", json.Number("18446744073709551615"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != uint64(18446744073709551615) {
+		t.Errorf("Got %#v", v)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	blobs := [][]byte{
+		{},
+		{0x00},
+		[]byte("a\x00b\x00"),
+		{0xff, 0xfe, 0xc3, 0x28, 0x80}, // invalid UTF-8
+		[]byte("\",\\\n`,"),            // separators and quotes
+		{0xe2, 0x82, 0xac, 0x00, 0xed, 0xa0, 0x80}, // valid rune, NUL, surrogate
+	}
+	r := &Restorer{columns: []string{"name", "data"}, kinds: []string{"string", "binary"}}
+	for _, blob := range blobs {
+		line := []byte(`"text",`)
+		encoded, err := table_dumper.EncodeValue("binary", blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = append(append(line, encoded...), '\n')
+		c := make(chan []interface{})
+		go NewReader(bytes.NewReader(line)).Parse(c)
+		total := 0
+		for row := range c {
+			total++
+			if err := r.decodeRow(row); err != nil {
+				t.Fatal(err)
+			}
+			if len(row) != 2 {
+				t.Fatalf("Got row of %d columns", len(row))
+			}
+			if b, ok := row[1].([]byte); !ok || !bytes.Equal(b, blob) {
+				t.Errorf("Expected %#v, got %#v", blob, row[1])
+			}
+		}
+		if total != 1 {
+			t.Errorf("Got %d rows", total)
+		}
+	}
+}
+
+func TestDecodeRowMismatch(t *testing.T) {
+	// the dump has more columns than the table
+	r := &Restorer{tableName: "users", columns: []string{"id"}, kinds: []string{"numeric", "binary", "binary"}}
+	if err := r.decodeRow([]interface{}{json.Number("1"), "not base64!", "AAH/"}); err == nil {
+		t.Error("Expected column number mismatch error")
+	}
+	if err := r.decodeRow([]interface{}{json.Number("1")}); err != nil {
+		t.Error(err)
+	}
+}
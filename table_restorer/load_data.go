@@ -0,0 +1,195 @@
+package table_restorer
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	MethodInsert   = "insert"
+	MethodLoadData = "load-data"
+)
+
+// IsMethod reports whether the restore method is known
+func IsMethod(method string) bool {
+	return method == MethodInsert || method == MethodLoadData
+}
+
+var (
+	loaders             uint64
+	errLoadDataFinished = errors.New("LOAD DATA finished")
+)
+
+// loader streams rows as TSV into LOAD DATA LOCAL INFILE running in background in a transaction,
+// so a load which is aborted leaves nothing behind
+type loader struct {
+	name string
+	tx   *sqlx.Tx
+	w    *io.PipeWriter
+	buf  *bufio.Writer
+	line []byte
+	done chan loadResult
+}
+
+// loadResult is how many rows LOAD DATA inserted
+type loadResult struct {
+	rows int64
+	err  error
+}
+
+func (r *Restorer) startLoad(ctx context.Context, conn *sqlx.DB) (*loader, error) {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error starting transaction")
+	}
+	pr, pw := io.Pipe()
+	l := &loader{
+		name: fmt.Sprintf("%s.%d", r.tableName, atomic.AddUint64(&loaders, 1)),
+		tx:   tx,
+		w:    pw,
+		buf:  bufio.NewWriterSize(pw, 64*1024),
+		done: make(chan loadResult, 1),
+	}
+	mysql.RegisterReaderHandler(l.name, func() io.Reader { return pr })
+	go func() {
+		var rows int64
+		result, err := tx.ExecContext(ctx, r.loadDataQuery(l.name))
+		if err == nil {
+			rows, err = result.RowsAffected()
+		}
+		if err != nil {
+			pr.CloseWithError(err) // unblock the writer if the server didn't read the data
+		} else {
+			pr.CloseWithError(errLoadDataFinished)
+		}
+		l.done <- loadResult{rows: rows, err: err}
+	}()
+	return l, nil
+}
+
+func (l *loader) write(row []interface{}) error {
+	l.line = l.line[:0]
+	for i, value := range row {
+		if i > 0 {
+			l.line = append(l.line, '\t')
+		}
+		l.line = appendTSV(l.line, value)
+	}
+	l.line = append(l.line, '\n')
+	_, err := l.buf.Write(l.line)
+	return err
+}
+
+// finish sends the rest of the rows, waits for LOAD DATA to complete, commits and returns how many rows it inserted.
+// With LOCAL, the server skips duplicate and bad rows with warnings instead of failing, so it can be less than sent
+func (l *loader) finish() (int, error) {
+	defer mysql.DeregisterReaderHandler(l.name)
+	if err := l.buf.Flush(); err != nil {
+		return 0, l.abort(err)
+	}
+	l.w.Close()
+	result := <-l.done
+	if result.err != nil {
+		l.tx.Rollback()
+		return 0, result.err
+	}
+	if err := l.tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "error committing LOAD DATA")
+	}
+	return int(result.rows), nil
+}
+
+// abort stops sending rows and rolls back the transaction. The driver still ends the file when the reader fails,
+// so the server loads the rows sent so far, they are rolled back rather than left in the table
+func (l *loader) abort(err error) error {
+	defer mysql.DeregisterReaderHandler(l.name)
+	l.w.CloseWithError(err)
+	result := <-l.done
+	if rollbackErr := l.tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+		log.Printf("Error rolling back LOAD DATA: %s", rollbackErr)
+	}
+	if result.err != nil {
+		return result.err
+	}
+	return err
+}
+
+// loadDataQuery returns LOAD DATA statement, binary and bit columns go through variables to be converted from text
+func (r *Restorer) loadDataQuery(handler string) string {
+	cols := make([]string, len(r.columns))
+	var set []string
+	for i, col := range r.columns {
+		kind := ""
+		if i < len(r.kinds) {
+			kind = r.kinds[i]
+		}
+		switch kind {
+		case "binary":
+			cols[i] = fmt.Sprintf("@c%d", i)
+			set = append(set, fmt.Sprintf("`%s`=UNHEX(@c%d)", col, i))
+		case "bit":
+			cols[i] = fmt.Sprintf("@c%d", i)
+			set = append(set, fmt.Sprintf("`%s`=CAST(@c%d AS UNSIGNED)", col, i))
+		default:
+			cols[i] = "`" + col + "`"
+		}
+	}
+	query := "LOAD DATA LOCAL INFILE 'Reader::" + handler + "' INTO TABLE `" + r.tableName + "` CHARACTER SET utf8mb4" +
+		` FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n'` +
+		" (" + strings.Join(cols, ",") + ")"
+	if len(set) > 0 {
+		query += " SET " + strings.Join(set, ",")
+	}
+	return query
+}
+
+// appendTSV appends the value escaped the way LOAD DATA expects, binary values as hex
+func appendTSV(line []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(line, `\N`...)
+	case []byte:
+		return append(line, hex.EncodeToString(v)...)
+	case json.Number:
+		return append(line, v...)
+	case uint64:
+		return strconv.AppendUint(line, v, 10)
+	case string:
+		return appendEscaped(line, v)
+	default:
+		return appendEscaped(line, fmt.Sprint(v))
+	}
+}
+
+func appendEscaped(line []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			line = append(line, `\\`...)
+		case '\t':
+			line = append(line, `\t`...)
+		case '\n':
+			line = append(line, `\n`...)
+		case '\r':
+			line = append(line, `\r`...)
+		case 0:
+			line = append(line, `\0`...)
+		default:
+			line = append(line, c)
+		}
+	}
+	return line
+}
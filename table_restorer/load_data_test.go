@@ -0,0 +1,37 @@
+package table_restorer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppendTSV(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `\N`},
+		{"", ""},
+		{`\N`, `\\N`},
+		{"a\tb\nc\rd\\e\x00f", `a\tb\nc\rd\\e\0f`},
+		{"юникод", "юникод"},
+		{json.Number("-12345678901234567890.000001"), "-12345678901234567890.000001"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{[]byte{0x00, 0xff, '\t'}, "00ff09"},
+	}
+	for _, c := range cases {
+		if got := string(appendTSV(nil, c.value)); got != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, got)
+		}
+	}
+}
+
+func TestLoadDataQuery(t *testing.T) {
+	r := New("", "t", []string{"id", "data", "flags"}).WithColumnKinds([]string{"numeric", "binary", "bit"})
+	expected := "LOAD DATA LOCAL INFILE 'Reader::t.1' INTO TABLE `t` CHARACTER SET utf8mb4" +
+		` FIELDS TERMINATED BY '\t' ESCAPED BY '\\' LINES TERMINATED BY '\n'` +
+		" (`id`,@c1,@c2) SET `data`=UNHEX(@c1),`flags`=CAST(@c2 AS UNSIGNED)"
+	if got := r.loadDataQuery("t.1"); got != expected {
+		t.Errorf("Got %q", got)
+	}
+}
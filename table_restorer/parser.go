@@ -3,12 +3,15 @@ package table_restorer
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
+	"strings"
 )
 
 type LineReader struct {
-	r *bufio.Reader
+	r    *bufio.Reader
+	line int
+	err  error
 }
 
 func NewReader(input io.Reader) *LineReader {
@@ -29,21 +32,21 @@ func (r *LineReader) Parse(row chan []interface{}) {
 	firstRune, _, err := r.r.ReadRune()
 	if err != nil {
 		if err != io.EOF {
-			log.Printf("Error reading: %s", err)
+			r.err = err
 		}
 		return
 	}
 	if firstRune == '`' {
 		if _, err := r.r.ReadString('\n'); err != nil {
 			if err != io.EOF {
-				log.Printf("Error reading: %s", err)
+				r.err = err
 			}
 			return
 		}
 	} else {
 		if err := r.r.UnreadRune(); err != nil {
 			if err != io.EOF {
-				log.Printf("Error reading: %s", err)
+				r.err = err
 			}
 			return
 		}
@@ -53,7 +56,7 @@ func (r *LineReader) Parse(row chan []interface{}) {
 		ru, _, err := r.r.ReadRune()
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("Error reading: %s", err)
+				r.err = err
 			}
 			return
 		}
@@ -64,7 +67,7 @@ func (r *LineReader) Parse(row chan []interface{}) {
 				ru, _, err := r.r.ReadRune()
 				if err != nil {
 					if err != io.EOF {
-						log.Printf("Error reading: %s", err)
+						r.err = err
 					}
 					return
 				}
@@ -89,13 +92,24 @@ func (r *LineReader) Parse(row chan []interface{}) {
 			column = append(column, ru)
 			escaped = !escaped
 		case '\n': // end of line
-			columns = append(columns, parseColumn(column))
+			r.line++
+			value, err := parseColumn(column)
+			if err != nil {
+				r.err = fmt.Errorf("line %d: %s", r.line, err)
+				return
+			}
+			columns = append(columns, value)
 			row <- columns
 			column = []rune{}
 			columns = []interface{}{}
 			escaped = false
 		case ',': // new column
-			columns = append(columns, parseColumn(column))
+			value, err := parseColumn(column)
+			if err != nil {
+				r.err = fmt.Errorf("line %d: %s", r.line+1, err)
+				return
+			}
+			columns = append(columns, value)
 			column = []rune{}
 			escaped = false
 		default: // just a character
@@ -105,13 +119,21 @@ func (r *LineReader) Parse(row chan []interface{}) {
 	}
 }
 
-func parseColumn(in []rune) interface{} {
+// Err returns the error which stopped parsing, it's valid once the rows channel is closed
+func (r *LineReader) Err() error {
+	return r.err
+}
+
+func parseColumn(in []rune) (interface{}, error) {
 	if len(in) == 0 {
-		return nil
+		return nil, nil
 	}
 	var value interface{}
-	if err := json.Unmarshal([]byte(string(in)), &value); err != nil {
-		log.Fatalf("error unmarshalling %s: %s", string(in), err)
+	// numbers are kept as their literal text, float64 would lose precision of BIGINT and DECIMAL
+	decoder := json.NewDecoder(strings.NewReader(string(in)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %s: %s", string(in), err)
 	}
-	return value
+	return value, nil
 }
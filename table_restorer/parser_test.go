@@ -2,6 +2,7 @@ package table_restorer
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"testing"
 )
@@ -15,12 +16,12 @@ func TestLineParser(t *testing.T) {
 			lines: []byte(`1050,,29,"yellowbot\"","2013-04-28 22:47:31\\\\",2,"Old: \"God sends food","Richard A\\","tap.\nI","","b0405f762ccefbc2bcf27b0a8522ea6ee76f5be4","\\\\tripadvisor  2",
 			`),
 			expected: []interface{}{
-				float64(1050),           // 0
+				json.Number("1050"),     // 0
 				nil,                     // 1
-				float64(29),             // 2
+				json.Number("29"),       // 2
 				"yellowbot\"",           // 3
 				`2013-04-28 22:47:31\\`, // 4
-				float64(2),              // 5
+				json.Number("2"),        // 5
 				`Old: "God sends food`,  // 6
 				"Richard A\\",           // 7
 				"tap.\nI",               // 8
@@ -34,12 +35,12 @@ func TestLineParser(t *testing.T) {
 			lines: []byte("`field`,`field2`\n" + `1050,,29,"yellowbot\"","2013-04-28 22:47:31\\\\",2,"Old: \"God sends food","Richard A\\","tap.\nI","","b0405f762ccefbc2bcf27b0a8522ea6ee76f5be4","\\\\tripadvisor  2",
 			`),
 			expected: []interface{}{
-				float64(1050),           // 0
+				json.Number("1050"),     // 0
 				nil,                     // 1
-				float64(29),             // 2
+				json.Number("29"),       // 2
 				"yellowbot\"",           // 3
 				`2013-04-28 22:47:31\\`, // 4
-				float64(2),              // 5
+				json.Number("2"),        // 5
 				`Old: "God sends food`,  // 6
 				"Richard A\\",           // 7
 				"tap.\nI",               // 8
@@ -49,6 +50,14 @@ func TestLineParser(t *testing.T) {
 				nil,
 			},
 		},
+		{
+			lines: []byte("18446744073709551615,-9007199254740993,12345678901234.123456\n"),
+			expected: []interface{}{
+				json.Number("18446744073709551615"),
+				json.Number("-9007199254740993"),
+				json.Number("12345678901234.123456"),
+			},
+		},
 		{
 			lines: []byte(`"one value"` + "\n"),
 			expected: []interface{}{
@@ -121,3 +130,19 @@ func TestLineParser2(t *testing.T) {
 	}
 	t.Logf("Total %d", total)
 }
+
+func TestLineParserError(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte("1,\"a\"\n2,tru\n3,\"c\"\n")))
+	c := make(chan []interface{})
+	go r.Parse(c)
+	total := 0
+	for range c {
+		total++
+	}
+	if total != 1 {
+		t.Errorf("Expected 1 row before the error, got %d", total)
+	}
+	if r.Err() == nil {
+		t.Error("Expected error for invalid value")
+	}
+}
@@ -1,44 +1,74 @@
 package table_restorer
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/BrightLocal/MySQLBackup/filter"
+	"github.com/BrightLocal/MySQLBackup/progress"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 )
 
 type stats struct {
 	rows     int
+	filtered int
+	failed   int
 	bytes    int
 	duration time.Duration
 }
 
 func (s stats) Rows() int               { return s.rows }
+func (s stats) Filtered() int           { return s.filtered }
+func (s stats) Failed() int             { return s.failed }
 func (s stats) Bytes() int              { return s.bytes }
 func (s stats) Duration() time.Duration { return s.duration }
 
+// countingReader counts bytes read, the count can be taken while another goroutine reads
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) count() int {
+	return int(atomic.LoadInt64(&c.n))
+}
+
 type Restorer struct {
-	dsn       string
-	tableName string
-	columns   []string
-	colNum    int
-	query     string
-	dryRun    bool
-	filter    filter.BoolExpr
+	dsn        string
+	tableName  string
+	columns    []string
+	kinds      []string
+	colNum     int
+	query      string
+	dryRun     bool
+	filter     filter.BoolExpr
+	batchRows  int
+	batchBytes int
+	method     string
+	progress   func(rows, bytes int)
 }
 
 func New(dsn, tableName string, columns []string) *Restorer {
 	r := &Restorer{
-		dsn:       dsn,
-		tableName: tableName,
-		columns:   columns,
-		colNum:    len(columns),
+		dsn:        dsn,
+		tableName:  tableName,
+		columns:    columns,
+		colNum:     len(columns),
+		batchRows:  1,
+		batchBytes: 1,
+		method:     MethodInsert,
 	}
 	r.query = "INSERT INTO `" + tableName + "` ("
 	cols := make([]string, len(columns), len(columns))
@@ -51,6 +81,31 @@ func New(dsn, tableName string, columns []string) *Restorer {
 	return r
 }
 
+// WithColumnKinds sets kinds of the columns, so values are decoded into what the columns hold
+func (r *Restorer) WithColumnKinds(kinds []string) *Restorer {
+	r.kinds = kinds
+	return r
+}
+
+// WithBatch sets how many rows and bytes at most are inserted by a single statement
+func (r *Restorer) WithBatch(rows, bytes int) *Restorer {
+	r.batchRows = rows
+	r.batchBytes = bytes
+	return r
+}
+
+// WithMethod sets how rows are restored, by INSERT statements or LOAD DATA
+func (r *Restorer) WithMethod(method string) *Restorer {
+	r.method = method
+	return r
+}
+
+// WithProgress sets a function to report rows and bytes read since its previous call
+func (r *Restorer) WithProgress(progress func(rows, bytes int)) *Restorer {
+	r.progress = progress
+	return r
+}
+
 func (r *Restorer) WithDryRun(dryRun bool) *Restorer {
 	r.dryRun = dryRun
 	return r
@@ -61,49 +116,159 @@ func (r *Restorer) WithFilter(filter filter.BoolExpr) *Restorer {
 	return r
 }
 
-func (r *Restorer) Run(in io.Reader, conn *sqlx.DB) (stats, error) {
+// Run restores rows read from the reader, it stops when the context is cancelled
+func (r *Restorer) Run(ctx context.Context, in io.Reader, conn *sqlx.DB) (s stats, err error) {
 	log.Printf("Restoring table %s: %s", r.tableName, strings.Join(r.columns, ", "))
-	l := NewReader(in)
+	start := time.Now()
+	counter := &countingReader{r: in}
+	defer func() {
+		s.bytes = counter.count()
+		s.duration = time.Since(start)
+	}()
+	l := NewReader(counter)
 	rows := make(chan []interface{})
 	go l.Parse(rows)
-	var statement *sql.Stmt
+	// let the parser finish when rows are not read to the end
+	defer func() {
+		go func() {
+			for range rows {
+			}
+		}()
+	}()
+	b := newBatch(r.tableName, r.columns, r.batchRows, r.batchBytes)
+	var load *loader
+	if !r.dryRun && r.method == MethodLoadData {
+		if load, err = r.startLoad(ctx, conn); err != nil {
+			return s, err
+		}
+	}
+	loaded := 0
+	read, reportedRows, reportedBytes := 0, 0, 0
+	report := func() {
+		if r.progress != nil {
+			bytes := counter.count()
+			r.progress(read-reportedRows, bytes-reportedBytes)
+			reportedRows, reportedBytes = read, bytes
+		}
+	}
+	defer report()
 
 	for row := range rows {
+		if err := ctx.Err(); err != nil {
+			if load != nil {
+				s.failed += loaded
+				return s, load.abort(err)
+			}
+			return s, err
+		}
+		read++
+		if read%progress.ReportRows == 0 {
+			report()
+		}
+		if err := r.decodeRow(row); err != nil {
+			log.Printf("Warning: %s", err)
+			s.failed++
+			continue
+		}
 		dataAsMap, err := r.getDataAsMap(row)
 		if err != nil {
 			log.Printf("Warning: %s", err)
+			s.failed++
 			continue
 		}
 		if r.filter != nil {
 			if doPass, err := r.filter.Value(dataAsMap); err != nil {
-				return stats{}, err
+				if load != nil {
+					s.failed += loaded
+					return s, load.abort(err)
+				}
+				return s, err
 			} else if !doPass {
+				s.filtered++
 				continue // skip row by filter expression
 			}
 		}
 
 		if r.dryRun {
 			fmt.Println(r.getRowSQL(row) + ";")
-		} else {
-			if statement == nil {
-				var err error
-				if statement, err = conn.Prepare(r.query); err != nil {
-					return stats{}, err
-				}
-				defer func() {
-					if err := statement.Close(); err != nil {
-						log.Printf("failed to close prepared statement: %s", err)
-						return
-					}
-				}()
+			s.rows++
+		} else if load != nil {
+			if err := load.write(row); err != nil {
+				s.failed += loaded + 1
+				return s, load.abort(err)
 			}
+			loaded++
+		} else if !b.add(row) {
+			r.flush(ctx, b, conn, &s)
+			b.add(row)
+		}
+	}
+	if err := l.Err(); err != nil {
+		err = errors.Wrap(err, "error parsing data file")
+		if load != nil {
+			s.failed += loaded
+			return s, load.abort(err)
+		}
+		if b.len() > 0 {
+			r.flush(ctx, b, conn, &s)
+		}
+		return s, err
+	}
+	if load != nil {
+		inserted, err := load.finish()
+		if err != nil {
+			s.failed += loaded
+			return s, err
+		}
+		if skipped := loaded - inserted; skipped > 0 {
+			log.Printf("Warning: LOAD DATA skipped %d of %d rows of table %s, e.g. duplicate keys or bad values", skipped, loaded, r.tableName)
+			s.failed += skipped
+		}
+		s.rows += inserted
+		return s, nil
+	}
+	if b.len() > 0 {
+		r.flush(ctx, b, conn, &s)
+	}
+	return s, nil
+}
 
-			if _, err := statement.Exec(row...); err != nil {
-				log.Printf("Warning: error executing query for table %s: %s\n%# v", r.tableName, err, row)
-			}
+// flush inserts the batch in a transaction, or row by row when that fails, so bad rows can be identified
+func (r *Restorer) flush(ctx context.Context, b *batch, conn *sqlx.DB, s *stats) {
+	defer b.reset()
+	query, args := b.query()
+	err := r.insertBatch(ctx, conn, query, args)
+	if err == nil {
+		s.rows += b.len()
+		return
+	}
+	if ctx.Err() != nil {
+		s.failed += b.len()
+		return
+	}
+	log.Printf("Warning: error inserting batch of %d rows into table %s, inserting row by row: %s", b.len(), r.tableName, err)
+	for _, row := range b.rows {
+		if _, err := conn.ExecContext(ctx, r.query, row...); err != nil {
+			log.Printf("Warning: error executing query for table %s: %s\n%# v", r.tableName, err, row)
+			s.failed++
+		} else {
+			s.rows++
+		}
+	}
+}
+
+func (r *Restorer) insertBatch(ctx context.Context, conn *sqlx.DB, query string, args []interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("failed to rollback transaction: %s", err)
 		}
+		return err
 	}
-	return stats{}, nil
+	return tx.Commit()
 }
 
 func (r *Restorer) getDataAsMap(data []interface{}) (map[string]interface{}, error) {
@@ -123,9 +288,11 @@ func (r *Restorer) getRowSQL(data []interface{}) string {
 	sql := r.query
 	for _, item := range data {
 		itemQuoted := ""
-		switch item.(type) {
+		switch v := item.(type) {
 		case string:
 			itemQuoted = fmt.Sprintf("%q", item)
+		case []byte:
+			itemQuoted = fmt.Sprintf("X'%x'", v)
 		default:
 			itemQuoted = fmt.Sprintf("%v", item)
 		}
@@ -1,11 +1,33 @@
 package worker_pool
 
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Job is a unit of work of the pool, Size estimates how long it takes so the largest jobs start first
+type Job interface {
+	Size() int64
+}
+
+// Result is the outcome of a job, a job which was not started is skipped with the error of the context
+type Result struct {
+	Job     Job
+	Err     error
+	Skipped bool
+}
+
 type workerPool struct {
-	n  int
-	fn func(interface{})
+	n        int
+	fn       func(context.Context, Job) error
+	failFast bool
 }
 
-func NewPool(n int, fn func(interface{})) *workerPool {
+func NewPool(n int, fn func(context.Context, Job) error) *workerPool {
+	if n < 1 {
+		n = 1
+	}
 	p := &workerPool{
 		n:  n,
 		fn: fn,
@@ -13,19 +35,72 @@ func NewPool(n int, fn func(interface{})) *workerPool {
 	return p
 }
 
-func (p *workerPool) Run(c chan interface{}) {
-	done := make(chan struct{})
-	for w := 1; w < p.n; w++ {
-		go p.runner(c, done)
+// WithFailFast makes the pool cancel remaining jobs after the first error
+func (p *workerPool) WithFailFast(failFast bool) *workerPool {
+	p.failFast = failFast
+	return p
+}
+
+// Run runs the jobs largest first until all are done or the context is cancelled,
+// it returns results of all jobs in the order they were scheduled
+func (p *workerPool) Run(ctx context.Context, jobs []Job) []Result {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]Result, len(jobs))
+	for i, job := range jobs {
+		results[i].Job = job
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Job.Size() > results[j].Job.Size() })
+	c := make(chan *Result)
+	go func() {
+		for i := range results {
+			c <- &results[i]
+		}
+		close(c)
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < p.n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runner(ctx, cancel, c)
+		}()
 	}
-	for w := 1; w < p.n; w++ {
-		<-done
+	wg.Wait()
+	return results
+}
+
+func (p *workerPool) runner(ctx context.Context, cancel context.CancelFunc, c chan *Result) {
+	for r := range c {
+		if err := ctx.Err(); err != nil {
+			r.Err, r.Skipped = err, true // drain the rest of the jobs
+			continue
+		}
+		r.Err = p.fn(ctx, r.Job)
+		if r.Err != nil && p.failFast {
+			cancel()
+		}
+	}
+}
+
+// Skipped returns jobs which were not started
+func Skipped(results []Result) []Job {
+	var jobs []Job
+	for _, r := range results {
+		if r.Skipped {
+			jobs = append(jobs, r.Job)
+		}
 	}
+	return jobs
 }
 
-func (p *workerPool) runner(c chan interface{}, done chan struct{}) {
-	for m := range c {
-		p.fn(m)
+// Errors returns errors of the results
+func Errors(results []Result) []error {
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
 	}
-	done <- struct{}{}
+	return errs
 }
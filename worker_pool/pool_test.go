@@ -0,0 +1,98 @@
+package worker_pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type job int64
+
+func (j job) Size() int64 { return int64(j) }
+
+func TestPoolLargestFirst(t *testing.T) {
+	var order []job
+	p := NewPool(1, func(ctx context.Context, j Job) error {
+		order = append(order, j.(job))
+		return nil
+	})
+	results := p.Run(context.Background(), []Job{job(1), job(5), job(3)})
+	if len(order) != 3 || order[0] != 5 || order[1] != 3 || order[2] != 1 {
+		t.Errorf("Got order %v", order)
+	}
+	if len(results) != 3 || results[0].Job != job(5) || len(Errors(results)) != 0 {
+		t.Errorf("Got results %+v", results)
+	}
+}
+
+func TestPoolStreams(t *testing.T) {
+	const n = 4
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := NewPool(n, func(ctx context.Context, j Job) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	go func() {
+		// all streams have to be busy at once before any of them is released
+		for i := 0; i < n; i++ {
+			<-started
+		}
+		close(release)
+	}()
+	results := p.Run(context.Background(), []Job{job(1), job(2), job(3), job(4)})
+	if len(Errors(results)) != 0 {
+		t.Errorf("Got results %+v", results)
+	}
+}
+
+func TestPoolErrors(t *testing.T) {
+	errFailed := errors.New("failed")
+	var mu sync.Mutex
+	ran := 0
+	fn := func(ctx context.Context, j Job) error {
+		mu.Lock()
+		ran++
+		mu.Unlock()
+		if j.(job) == 3 {
+			return errFailed
+		}
+		return nil
+	}
+	jobs := []Job{job(1), job(2), job(3), job(4)}
+	if errs := Errors(NewPool(2, fn).Run(context.Background(), jobs)); len(errs) != 1 || errs[0] != errFailed {
+		t.Errorf("Got errors %v", errs)
+	}
+	if ran != 4 {
+		t.Errorf("Expected all jobs to run, got %d", ran)
+	}
+	ran = 0
+	results := NewPool(1, fn).WithFailFast(true).Run(context.Background(), jobs)
+	if ran != 2 {
+		t.Errorf("Expected jobs to stop after the error, got %d", ran)
+	}
+	if results[0].Err != nil || results[1].Err != errFailed || !results[2].Skipped || results[3].Err != context.Canceled {
+		t.Errorf("Got results %+v", results)
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran := false
+	results := NewPool(2, func(ctx context.Context, j Job) error {
+		ran = true
+		return nil
+	}).Run(ctx, []Job{job(1), job(2)})
+	if ran {
+		t.Error("Expected no jobs to run")
+	}
+	if errs := Errors(results); len(errs) != 2 {
+		t.Errorf("Got errors %v", errs)
+	}
+	if skipped := Skipped(results); len(skipped) != 2 {
+		t.Errorf("Got skipped %v", skipped)
+	}
+}